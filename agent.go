@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// forwardAgentInput is the index of the agent forwarding toggle in the
+// server form
+const forwardAgentInput = 11
+
+var errNoAgent = errors.New("no ssh-agent running (SSH_AUTH_SOCK is not set)")
+
+// dialAgent connects to the ssh-agent of the user's session
+func dialAgent() (net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errNoAgent
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("ssh-agent: %v", err)
+	}
+	return conn, nil
+}
+
+// agentKeys lists the keys loaded in ssh-agent
+func agentKeys() ([]*agent.Key, error) {
+	conn, err := dialAgent()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return agent.NewClient(conn).List()
+}
+
+// agentAuth authenticates with the keys of an agent, or only with the key
+// with the given SHA256 fingerprint if one is set.
+func agentAuth(ag agent.Agent, fingerprint string) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		signers, err := ag.Signers()
+		if err != nil || fingerprint == "" {
+			return signers, err
+		}
+		for _, s := range signers {
+			if ssh.FingerprintSHA256(s.PublicKey()) == fingerprint {
+				return []ssh.Signer{s}, nil
+			}
+		}
+		return nil, fmt.Errorf("key %s is not loaded in ssh-agent", fingerprint)
+	})
+}
+
+// forwardAgent makes the local ssh-agent available to the remote side of
+// session, as ssh -A does.
+func forwardAgent(client *ssh.Client, session *ssh.Session) error {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return errNoAgent
+	}
+	if err := agent.ForwardToRemote(client, sock); err != nil {
+		return err
+	}
+	return agent.RequestAgentForwarding(session)
+}
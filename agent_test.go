@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testSSHServer runs an SSH server that authenticates with config and
+// refuses every channel, for testing how clients log in.
+func testSSHServer(t *testing.T, config *ssh.ServerConfig) (host string, port int) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				defer sc.Close()
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "test server")
+				}
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// testAgent serves keyring as the ssh-agent of the test
+func testAgent(t *testing.T, keyring agent.Agent) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+}
+
+func TestDialSSHWithAgent(t *testing.T) {
+	keyring := agent.NewKeyring()
+	var fingerprints []string
+	for _, comment := range []string{"laptop", "deploy"} {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprints = append(fingerprints, ssh.FingerprintSHA256(signer.PublicKey()))
+	}
+	laptop, deploy := fingerprints[0], fingerprints[1]
+
+	// The server takes the deploy key only
+	var mu sync.Mutex
+	var offered []string
+	host, port := testSSHServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			fp := ssh.FingerprintSHA256(key)
+			offered = append(offered, fp)
+			if fp != deploy {
+				return nil, errors.New("key refused")
+			}
+			return nil, nil
+		},
+	})
+
+	tests := []struct {
+		name        string
+		agentKey    string
+		noAgent     bool
+		wantErr     string
+		wantOffered []string
+	}{
+		{name: "any key", wantOffered: []string{laptop, deploy}},
+		{name: "chosen key", agentKey: deploy, wantOffered: []string{deploy}},
+		{name: "chosen key refused", agentKey: laptop, wantErr: "unable to authenticate", wantOffered: []string{laptop}},
+		{name: "chosen key not loaded", agentKey: "SHA256:missing", wantErr: "key SHA256:missing is not loaded in ssh-agent"},
+		{name: "no agent", noAgent: true, wantErr: "no password or key stored for web, and " + errNoAgent.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testAgent(t, keyring)
+			if tt.noAgent {
+				t.Setenv("SSH_AUTH_SOCK", "")
+			}
+			mu.Lock()
+			offered = nil
+			mu.Unlock()
+
+			server := Server{Name: "web", Host: host, Port: port, Username: "deploy", AgentKey: tt.agentKey, HostKeyPolicy: hostKeyTOFU}
+			hostKeys := newKnownHostsStore(filepath.Join(t.TempDir(), "known_hosts"))
+			client, err := dialSSH(context.Background(), &server, port, hostKeys, nil)
+			if err == nil {
+				client.Close()
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("dialSSH() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("dialSSH() error = %v, want %q", err, tt.wantErr)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.Join(offered, " ") != strings.Join(tt.wantOffered, " ") {
+				t.Errorf("offered %q, want %q", offered, tt.wantOffered)
+			}
+		})
+	}
+}
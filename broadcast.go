@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+// broadcastParallel limits how many servers a broadcast connects to at once
+const broadcastParallel = 8
+
+// serverMarks holds the IDs of the servers marked in the list. The list
+// delegate shares the map, so it is changed in place and never replaced.
+type serverMarks map[int]bool
+
+// serverDelegate is the default server list delegate with marked servers
+// drawn in the mark color.
+type serverDelegate struct {
+	list.DefaultDelegate
+	marked serverMarks
+}
+
+func (d serverDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	if server, ok := listServer(item); ok && d.marked[server.ID] {
+		mark := lipgloss.Color("214")
+		d.Styles.NormalTitle = d.Styles.NormalTitle.Copy().Foreground(mark)
+		d.Styles.SelectedTitle = d.Styles.SelectedTitle.Copy().Foreground(mark)
+		d.Styles.DimmedTitle = d.Styles.DimmedTitle.Copy().Foreground(mark)
+	}
+	d.DefaultDelegate.Render(w, m, index, item)
+}
+
+// toggleServerMark marks or unmarks the selected server and moves to the
+// next one.
+func (m *model) toggleServerMark() {
+	server, ok := listServer(m.list.SelectedItem())
+	if !ok {
+		return
+	}
+	if m.serverMarks[server.ID] {
+		delete(m.serverMarks, server.ID)
+	} else {
+		m.serverMarks[server.ID] = true
+	}
+	m.list.CursorDown()
+}
+
+// markedServers returns the marked servers in config order, or else the
+// selected server or the servers of the selected group, resolved against
+// their groups.
+func (m model) markedServers() []Server {
+	var servers []Server
+	for _, server := range m.config.Servers {
+		if m.serverMarks[server.ID] {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == 0 {
+		switch item := m.list.SelectedItem().(type) {
+		case serverItem:
+			servers = append(servers, item.Server)
+		case groupItem:
+			servers = m.config.groupServers(item.ID)
+		}
+	}
+	for i := range servers {
+		servers[i] = m.connectServer(servers[i])
+	}
+	return servers
+}
+
+// runOnServer connects to server and runs command. Servers without stored
+// credentials authenticate with the keys of ssh-agent, like an interactive
+// session would.
+func runOnServer(ctx context.Context, server Server, hostKeys *knownHostsStore, command string) commandResult {
+	started := time.Now()
+	res := runWithClient(ctx, server, hostKeys, command)
+	res.Command = command
+	res.Duration = time.Since(started)
+	return res
+}
+
+func runWithClient(ctx context.Context, server Server, hostKeys *knownHostsStore, command string) commandResult {
+	client, err := dialSSH(ctx, &server, server.Port, hostKeys, nil)
+	if err != nil {
+		return commandResult{Status: -1, Err: broadcastDialError(err)}
+	}
+	defer client.Close()
+
+	c, err := startCommand(client, command)
+	if err != nil {
+		return commandResult{Status: -1, Err: err}
+	}
+	stop := context.AfterFunc(ctx, c.Cancel)
+	defer stop()
+	return c.Wait()
+}
+
+// broadcastDialError explains host key problems, which cannot be resolved
+// from a broadcast since it would need a dialog per server.
+func broadcastDialError(err error) error {
+	var hkErr *hostKeyError
+	if errors.As(err, &hkErr) {
+		return fmt.Errorf("%v; connect to it once to verify the key", hkErr)
+	}
+	return err
+}
+
+// broadcastTarget is one server of a broadcast and its result
+type broadcastTarget struct {
+	Server  Server
+	Running bool
+	Result  commandResult
+}
+
+// broadcast is the state of the broadcast view
+type broadcast struct {
+	Command  string
+	targets  []broadcastTarget
+	input    textinput.Model
+	table    table.Model
+	viewport viewport.Model
+	detail   int // -1 for the table, len(targets) for the combined output, else a target
+	started  bool
+	run      int // tells results of this run from cancelled ones
+	cancel   context.CancelFunc
+}
+
+type broadcastResultMsg struct {
+	broadcast *broadcast // the broadcast the result belongs to
+	run       int
+	index     int
+	result    commandResult
+}
+
+// running returns how many servers have not finished
+func (b *broadcast) running() int {
+	n := 0
+	for _, t := range b.targets {
+		if t.Running {
+			n++
+		}
+	}
+	return n
+}
+
+// outputPreview is the first line of output of a result
+func outputPreview(res commandResult) string {
+	for _, out := range []string{res.Stdout, res.Stderr} {
+		for _, line := range strings.Split(out, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				return escapeControl(strings.ReplaceAll(line, "\t", " "))
+			}
+		}
+	}
+	return ""
+}
+
+// refreshTable fills the table rows from the targets
+func (b *broadcast) refreshTable() {
+	rows := make([]table.Row, len(b.targets))
+	for i, t := range b.targets {
+		status, took := "running...", ""
+		if !t.Running {
+			status = t.Result.Outcome()
+			took = t.Result.Duration.Round(time.Millisecond).String()
+		}
+		preview := outputPreview(t.Result)
+		if t.Result.Err != nil {
+			status, preview = "error", t.Result.Err.Error()
+		}
+		rows[i] = table.Row{t.Server.Name, status, took, preview}
+	}
+	b.table.SetRows(rows)
+}
+
+// renderDetail shows the output of one target, or of all of them
+func (b *broadcast) renderDetail() {
+	var out strings.Builder
+	for i, t := range b.targets {
+		if b.detail != len(b.targets) && b.detail != i {
+			continue
+		}
+		header := fmt.Sprintf("── %s: running", t.Server.Name)
+		if !t.Running {
+			header = fmt.Sprintf("── %s: %s in %s", t.Server.Name, t.Result.Outcome(), t.Result.Duration.Round(time.Millisecond))
+		}
+		style := commandOKStyle
+		if !t.Running && !t.Result.OK() {
+			style = commandFailStyle
+		}
+		out.WriteString(style.Render(header) + "\n")
+		out.WriteString(t.Result.output())
+		out.WriteString("\n")
+	}
+	b.viewport.SetContent(strings.TrimSuffix(out.String(), "\n"))
+}
+
+// --- Broadcast view ---
+
+// openBroadcast asks for a command to run on the marked servers
+func (m *model) openBroadcast() tea.Cmd {
+	servers := m.markedServers()
+	if len(servers) == 0 {
+		return nil
+	}
+
+	input := textinput.New()
+	input.Prompt = "$ "
+	input.Placeholder = "command"
+	input.CharLimit = 1024
+
+	b := &broadcast{input: input, viewport: viewport.New(0, 0), detail: -1}
+	for _, server := range servers {
+		b.targets = append(b.targets, broadcastTarget{Server: server})
+	}
+	b.table = table.New(
+		table.WithColumns(broadcastColumns(m.width)),
+		table.WithFocused(true),
+	)
+	m.broadcast = b
+	m.resizeBroadcast()
+	m.state = broadcastView
+	m.message = ""
+	return b.input.Focus()
+}
+
+// broadcastColumns sizes the result table, giving the output preview what
+// is left of the width.
+func broadcastColumns(width int) []table.Column {
+	preview := width - 20 - 24 - 10 - 12
+	if preview < 20 {
+		preview = 20
+	}
+	return []table.Column{
+		{Title: "Server", Width: 20},
+		{Title: "Status", Width: 24},
+		{Title: "Time", Width: 10},
+		{Title: "Output", Width: preview},
+	}
+}
+
+func (m *model) resizeBroadcast() {
+	b := m.broadcast
+	if b == nil {
+		return
+	}
+	height := m.height - 8
+	if height < 3 {
+		height = 3
+	}
+	b.input.Width = m.width - 8
+	b.table.SetColumns(broadcastColumns(m.width))
+	b.table.SetHeight(height)
+	b.viewport.Width = m.width - 2
+	b.viewport.Height = height
+	if b.detail >= 0 {
+		b.renderDetail()
+	}
+}
+
+// startBroadcast runs the command on every target at once, at most
+// broadcastParallel connecting at a time.
+func (m *model) startBroadcast() tea.Cmd {
+	b := m.broadcast
+	b.Command = strings.TrimSpace(b.input.Value())
+	if b.Command == "" {
+		return nil
+	}
+	b.input.Blur()
+	b.started = true
+	b.run++
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	slots := make(chan struct{}, broadcastParallel)
+	cmds := make([]tea.Cmd, len(b.targets))
+	for i := range b.targets {
+		b.targets[i].Running = true
+		b.targets[i].Result = commandResult{}
+		i, run, server, command, hostKeys := i, b.run, b.targets[i].Server, b.Command, m.knownHosts
+		cmds[i] = func() tea.Msg {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			var res commandResult
+			if ctx.Err() != nil {
+				res = commandResult{Command: command, Status: -1, Err: errors.New("cancelled")}
+			} else {
+				res = runOnServer(ctx, server, hostKeys, command)
+			}
+			return broadcastResultMsg{broadcast: b, run: run, index: i, result: res}
+		}
+	}
+	b.refreshTable()
+	return tea.Batch(cmds...)
+}
+
+// broadcastResult records the result of one server
+func (m *model) broadcastResult(msg broadcastResultMsg) {
+	b := m.broadcast
+	if b == nil || b != msg.broadcast || b.run != msg.run || msg.index >= len(b.targets) {
+		return
+	}
+	b.targets[msg.index].Running = false
+	b.targets[msg.index].Result = msg.result
+	b.refreshTable()
+	if b.detail >= 0 {
+		b.renderDetail()
+	}
+	if b.running() == 0 {
+		b.cancel()
+	}
+}
+
+// stop cancels the servers still running
+func (b *broadcast) stop() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+func (m model) updateBroadcastView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	b := m.broadcast
+
+	if !b.started {
+		switch msg.String() {
+		case "esc", "ctrl+c":
+			m.broadcast = nil
+			m.state = listView
+			return m, nil
+		case "enter":
+			cmd := m.startBroadcast()
+			return m, cmd
+		}
+		var cmd tea.Cmd
+		b.input, cmd = b.input.Update(msg)
+		return m, cmd
+	}
+
+	if b.detail >= 0 {
+		switch msg.String() {
+		case "esc", "q":
+			b.detail = -1
+			return m, nil
+		}
+		var cmd tea.Cmd
+		b.viewport, cmd = b.viewport.Update(msg)
+		return m, cmd
+	}
+
+	switch msg.String() {
+	case "esc", "q", "ctrl+c":
+		b.stop()
+		m.broadcast = nil
+		m.state = listView
+		// The marks were for this broadcast
+		m.serverMarks.clear()
+		return m, nil
+
+	case "x":
+		if b.running() > 0 {
+			b.stop()
+			m.message = "Cancelling the remaining servers"
+		}
+		return m, nil
+
+	case "enter":
+		b.detail = b.table.Cursor()
+		b.renderDetail()
+		b.viewport.GotoTop()
+		return m, nil
+
+	case "o":
+		b.detail = len(b.targets)
+		b.renderDetail()
+		b.viewport.GotoTop()
+		return m, nil
+
+	case "n":
+		// Run another command on the same servers
+		if b.running() > 0 {
+			return m, nil
+		}
+		b.started = false
+		b.input.SetValue(b.Command)
+		b.input.CursorEnd()
+		m.message = ""
+		return m, b.input.Focus()
+	}
+
+	var cmd tea.Cmd
+	b.table, cmd = b.table.Update(msg)
+	return m, cmd
+}
+
+func (m model) viewBroadcast() string {
+	b := m.broadcast
+	var s strings.Builder
+
+	if !b.started {
+		names := make([]string, len(b.targets))
+		for i, t := range b.targets {
+			names[i] = t.Server.Name
+		}
+		s.WriteString(titleStyle.Render(fmt.Sprintf("Broadcast to %d servers", len(b.targets))) + "\n")
+		s.WriteString(helpStyle.Render(runewidth.Wrap(strings.Join(names, ", "), m.width-4)) + "\n\n")
+		s.WriteString("  " + b.input.View() + "\n\n")
+		s.WriteString(helpStyle.Render("[enter] run on all • [esc] back"))
+	} else {
+		ok, failed := 0, 0
+		for _, t := range b.targets {
+			if t.Running {
+				continue
+			}
+			if t.Result.OK() {
+				ok++
+			} else {
+				failed++
+			}
+		}
+		s.WriteString(titleStyle.Render("Broadcast: $ "+b.Command) + "\n")
+		summary := fmt.Sprintf("%d ok • %d failed", ok, failed)
+		if running := b.running(); running > 0 {
+			summary += fmt.Sprintf(" • %d running", running)
+		}
+		s.WriteString(helpStyle.Render(summary) + "\n\n")
+
+		if b.detail >= 0 {
+			s.WriteString(b.viewport.View() + "\n\n")
+			s.WriteString(helpStyle.Render("[↑/↓] scroll • [esc] back to results"))
+		} else {
+			s.WriteString(b.table.View() + "\n\n")
+			s.WriteString(helpStyle.Render("[enter] server output • [o] combined output • [n]ew command • [x] cancel running • [esc] back"))
+		}
+	}
+
+	if m.message != "" {
+		msgStyle := messageStyle
+		if strings.HasPrefix(m.message, "Error") {
+			msgStyle = errorStyle
+		}
+		s.WriteString("\n" + msgStyle.Render(m.message))
+	}
+	return s.String()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// silentServer accepts connections and never answers, like a host that
+// hangs in the SSH handshake.
+func silentServer(t *testing.T) (host string, port int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestRunWithClientCancelledWhileConnecting(t *testing.T) {
+	host, port := silentServer(t)
+	server := Server{Name: "slow", Host: host, Port: port, Username: "u", Password: "p"}
+	hostKeys := newKnownHostsStore(filepath.Join(t.TempDir(), "known_hosts"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan commandResult)
+	go func() { done <- runWithClient(ctx, server, hostKeys, "true") }()
+
+	select {
+	case res := <-done:
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("runWithClient() error = %v, want %v", res.Err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelling did not stop the handshake")
+	}
+}
+
+func TestBroadcastClearsMarks(t *testing.T) {
+	host, port := silentServer(t)
+	config := &Config{Servers: []Server{
+		{ID: 1, Name: "a", Host: host, Port: port, Password: "p"},
+		{ID: 2, Name: "b", Host: host, Port: port, Password: "p"},
+		{ID: 3, Name: "c", Host: host, Port: port, Password: "p"},
+	}}
+	marks := serverMarks{1: true, 3: true}
+	m := model{
+		config:      config,
+		serverMarks: marks,
+		knownHosts:  newKnownHostsStore(filepath.Join(t.TempDir(), "known_hosts")),
+		list:        list.New(nil, list.NewDefaultDelegate(), 0, 0),
+		width:       100,
+		height:      30,
+	}
+
+	m.openBroadcast()
+	if len(m.broadcast.targets) != 2 {
+		t.Fatalf("broadcast to %d servers, want 2", len(m.broadcast.targets))
+	}
+	// Closing before running keeps the marks
+	next, _ := m.updateBroadcastView(tea.KeyMsg{Type: tea.KeyEsc})
+	m = next.(model)
+	if len(marks) != 2 {
+		t.Fatalf("marks = %v after closing an unsent broadcast", marks)
+	}
+
+	m.openBroadcast()
+	m.broadcast.input.SetValue("uptime")
+	next, cmd := m.updateBroadcastView(tea.KeyMsg{Type: tea.KeyEnter})
+	m = next.(model)
+	if cmd == nil || m.broadcast.running() != 2 {
+		t.Fatal("broadcast did not start")
+	}
+	b := m.broadcast
+	next, _ = m.updateBroadcastView(tea.KeyMsg{Type: tea.KeyEsc})
+	m = next.(model)
+	if len(marks) != 0 || m.state != listView {
+		t.Errorf("marks = %v, state %v after closing a broadcast", marks, m.state)
+	}
+
+	// The closed broadcast's servers stop connecting
+	results := make(chan tea.Msg, len(b.targets))
+	for _, c := range cmd().(tea.BatchMsg) {
+		go func(c tea.Cmd) { results <- c() }(c)
+	}
+	for range b.targets {
+		select {
+		case msg := <-results:
+			if res := msg.(broadcastResultMsg).result; res.Err == nil {
+				t.Errorf("closed broadcast ran: %+v", res)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("servers of a closed broadcast kept connecting")
+		}
+	}
+}
+
+func TestOutputPreview(t *testing.T) {
+	tests := []struct {
+		name   string
+		result commandResult
+		want   string
+	}{
+		{name: "empty", result: commandResult{}, want: ""},
+		{name: "first line", result: commandResult{Stdout: "\n  up 3 days\nload 0.1\n"}, want: "up 3 days"},
+		{name: "stderr when no stdout", result: commandResult{Stdout: "\n", Stderr: "not found\n"}, want: "not found"},
+		{name: "tabs", result: commandResult{Stdout: "a\tb"}, want: "a b"},
+		{name: "control characters", result: commandResult{Stdout: "\x1b]0;title\x07done"}, want: `\x1b]0;title\x07done`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outputPreview(tt.result); got != tt.want {
+				t.Errorf("outputPreview() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBroadcastResult(t *testing.T) {
+	newBroadcast := func() *broadcast {
+		b := &broadcast{
+			targets: []broadcastTarget{{Server: Server{Name: "a"}, Running: true}, {Server: Server{Name: "b"}, Running: true}},
+			detail:  -1,
+			run:     2,
+			cancel:  func() {},
+		}
+		b.table = table.New(table.WithColumns(broadcastColumns(100)))
+		return b
+	}
+	other := newBroadcast()
+
+	tests := []struct {
+		name    string
+		msg     func(b *broadcast) broadcastResultMsg
+		wantSet bool
+	}{
+		{name: "current run", msg: func(b *broadcast) broadcastResultMsg { return broadcastResultMsg{broadcast: b, run: 2, index: 1} }, wantSet: true},
+		{name: "earlier run", msg: func(b *broadcast) broadcastResultMsg { return broadcastResultMsg{broadcast: b, run: 1, index: 1} }},
+		{name: "other broadcast", msg: func(b *broadcast) broadcastResultMsg { return broadcastResultMsg{broadcast: other, run: 2, index: 1} }},
+		{name: "out of range", msg: func(b *broadcast) broadcastResultMsg { return broadcastResultMsg{broadcast: b, run: 2, index: 2} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBroadcast()
+			m := model{broadcast: b}
+			msg := tt.msg(b)
+			msg.result = commandResult{Stdout: "ok"}
+			m.broadcastResult(msg)
+			if set := !b.targets[1].Running; set != tt.wantSet {
+				t.Errorf("result recorded = %v, want %v", set, tt.wantSet)
+			}
+			if !b.targets[0].Running {
+				t.Error("result recorded for the wrong server")
+			}
+		})
+	}
+
+	t.Run("closed broadcast", func(t *testing.T) {
+		// Results arriving after the view closed are dropped without a panic
+		m := model{}
+		m.broadcastResult(broadcastResultMsg{broadcast: other, run: 2})
+	})
+}
@@ -67,17 +67,12 @@ if [ $? -eq 0 ]; then
     echo "To install: sudo mv termius-from-walmart /usr/local/bin/"
     echo ""
     
-    # Check if sshpass is installed
-    if ! command -v sshpass &> /dev/null; then
-        echo -e "${YELLOW}Note: 'sshpass' is not installed.${NC}"
-        echo "For password-based SSH connections, install sshpass:"
-        echo "  Ubuntu/Debian: sudo apt-get install sshpass"
-        echo "  macOS: brew install hudochenkov/sshpass/sshpass"
-        echo "  Fedora: sudo dnf install sshpass"
+    # Servers without a stored password or PEM key use the system ssh client
+    if ! command -v ssh &> /dev/null; then
+        echo -e "${YELLOW}Note: 'ssh' is not installed.${NC}"
+        echo "Servers without a saved password or PEM key connect through the"
+        echo "system ssh client and will not work until it is installed."
         echo ""
-        echo "Alternatively, use SSH keys (recommended)."
-    else
-        echo -e "${GREEN}✓ sshpass is installed${NC}"
     fi
     
 else
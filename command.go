@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	commandOutputMax  = 1 << 20 // bytes kept of each of stdout and stderr
+	commandHistoryMax = 50      // commands remembered per server
+	commandResultsMax = 20      // results kept in the panel
+)
+
+var (
+	commandStderrStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	commandOKStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	commandFailStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+)
+
+// cappedBuffer keeps the first commandOutputMax bytes written to it and
+// drops the rest, so a chatty command cannot exhaust memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := commandOutputMax - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// remoteCommand is a command running in its own session on the SSH
+// connection of an SFTPManager.
+type remoteCommand struct {
+	Command string
+	session *ssh.Session
+	stdout  cappedBuffer
+	stderr  cappedBuffer
+	started time.Time
+}
+
+// commandResult is the outcome of a remote command. Status is -1 if the
+// command ended without an exit status, e.g. when it was cancelled.
+type commandResult struct {
+	Command   string
+	Dir       string
+	Stdout    string
+	Stderr    string
+	Status    int
+	Err       error
+	Duration  time.Duration
+	Truncated bool
+}
+
+// OK reports whether the command ran and exited with status 0
+func (res commandResult) OK() bool {
+	return res.Err == nil && res.Status == 0
+}
+
+// Outcome describes how the command ended
+func (res commandResult) Outcome() string {
+	switch {
+	case res.Err != nil:
+		return fmt.Sprintf("failed: %v", res.Err)
+	case res.Status == -1:
+		return "ended without exit status"
+	}
+	return fmt.Sprintf("exit %d", res.Status)
+}
+
+// output renders stdout followed by stderr in its own color, one line per
+// line of output, with control characters escaped.
+func (res commandResult) output() string {
+	var b strings.Builder
+	if res.Stdout != "" {
+		b.WriteString(escapeControl(strings.TrimSuffix(res.Stdout, "\n")) + "\n")
+	}
+	if res.Stderr != "" {
+		for _, line := range strings.Split(escapeControl(strings.TrimSuffix(res.Stderr, "\n")), "\n") {
+			b.WriteString(commandStderrStyle.Render(line) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// StartCommand runs command through the user's shell on the server
+func (sm *SFTPManager) StartCommand(command string) (*remoteCommand, error) {
+	return startCommand(sm.conn, command)
+}
+
+// startCommand runs command in a new session on conn
+func startCommand(conn *ssh.Client, command string) (*remoteCommand, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	c := &remoteCommand{Command: command, session: session, started: time.Now()}
+	session.Stdout = &c.stdout
+	session.Stderr = &c.stderr
+	if err := session.Start(command); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Wait waits for the command to exit and collects its output
+func (c *remoteCommand) Wait() commandResult {
+	err := c.session.Wait()
+	c.session.Close()
+	res := commandResult{
+		Command:   c.Command,
+		Stdout:    c.stdout.String(),
+		Stderr:    c.stderr.String(),
+		Duration:  time.Since(c.started),
+		Truncated: c.stdout.truncated || c.stderr.truncated,
+	}
+
+	var exitErr *ssh.ExitError
+	var missing *ssh.ExitMissingError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		res.Status = exitErr.ExitStatus()
+	case errors.As(err, &missing):
+		res.Status = -1
+	default:
+		res.Status = -1
+		res.Err = err
+	}
+	return res
+}
+
+// Cancel interrupts the command and closes its session. Servers that do
+// not deliver signals still end the command when the session closes,
+// unless it ignores the hangup.
+func (c *remoteCommand) Cancel() {
+	c.session.Signal(ssh.SIGINT)
+	c.session.Close()
+}
+
+// commandPanel is the state of the command view
+type commandPanel struct {
+	server   *Server
+	manager  *SFTPManager
+	Dir      string // commands run in this directory
+	input    textinput.Model
+	viewport viewport.Model
+	results  []commandResult
+	running  *remoteCommand
+	// History browsing: histIndex is len(history) while editing a new
+	// command, whose text is kept in draft.
+	histIndex int
+	draft     string
+}
+
+type commandDoneMsg struct {
+	cmd    *remoteCommand
+	result commandResult
+}
+
+func waitForCommand(c *remoteCommand) tea.Cmd {
+	return func() tea.Msg {
+		return commandDoneMsg{cmd: c, result: c.Wait()}
+	}
+}
+
+// commandHistory returns the commands run on a server, oldest first
+func (m model) commandHistory(id int) []string {
+	return m.config.CommandHistory[id]
+}
+
+// rememberCommand adds command to the server's history, moving it to the
+// end if it was run before.
+func (m *model) rememberCommand(id int, command string) {
+	if m.config.CommandHistory == nil {
+		m.config.CommandHistory = map[int][]string{}
+	}
+	var history []string
+	for _, c := range m.config.CommandHistory[id] {
+		if c != command {
+			history = append(history, c)
+		}
+	}
+	history = append(history, command)
+	if len(history) > commandHistoryMax {
+		history = history[len(history)-commandHistoryMax:]
+	}
+	m.config.CommandHistory[id] = history
+}
+
+// --- Command view ---
+
+// openCommandPanel opens the command panel for the server of the focused
+// pane, running in that pane's directory.
+func (m *model) openCommandPanel() tea.Cmd {
+	manager, server, dir := m.sftpManager, m.selectedServer, m.remotePath
+	if m.focusPane == "local" && m.leftManager != nil {
+		manager, server, dir = m.leftManager, m.leftServer, m.localPath
+	}
+	if manager == nil || server == nil {
+		m.message = "Error: SFTP connection lost"
+		return nil
+	}
+
+	input := textinput.New()
+	input.Prompt = "$ "
+	input.Placeholder = "command"
+	input.CharLimit = 1024
+	p := &commandPanel{server: server, manager: manager, Dir: dir, input: input, viewport: viewport.New(0, 0)}
+	p.histIndex = len(m.commandHistory(server.ID))
+	m.commandPanel = p
+	m.resizeCommandPanel()
+	m.state = commandView
+	m.message = ""
+	return p.input.Focus()
+}
+
+func (m *model) resizeCommandPanel() {
+	p := m.commandPanel
+	if p == nil {
+		return
+	}
+	p.input.Width = m.width - 8
+	p.viewport.Width = m.width - 2
+	p.viewport.Height = m.height - 8
+	if p.viewport.Height < 3 {
+		p.viewport.Height = 3
+	}
+	p.render()
+}
+
+// render lays out the results, oldest first, and scrolls to the newest
+func (p *commandPanel) render() {
+	var b strings.Builder
+	for i, res := range p.results {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(helpStyle.Render(fmt.Sprintf("%s $ %s", res.Dir, res.Command)) + "\n")
+		b.WriteString(res.output())
+
+		status := commandOKStyle.Render(res.Outcome())
+		if !res.OK() {
+			status = commandFailStyle.Render(res.Outcome())
+		}
+		status += helpStyle.Render(fmt.Sprintf("in %s", res.Duration.Round(time.Millisecond)))
+		if res.Truncated {
+			status += helpStyle.Render(fmt.Sprintf("output cut at %s", FormatSize(commandOutputMax)))
+		}
+		b.WriteString(status + "\n")
+	}
+	p.viewport.SetContent(strings.TrimSuffix(b.String(), "\n"))
+	p.viewport.GotoBottom()
+}
+
+// runCommand starts the command typed in the panel and records it in the
+// server's history.
+func (m *model) runCommand() tea.Cmd {
+	p := m.commandPanel
+	command := strings.TrimSpace(p.input.Value())
+	if command == "" || p.running != nil {
+		return nil
+	}
+
+	c, err := p.manager.StartCommand("cd " + shellQuote(p.Dir) + " && " + command)
+	if err != nil {
+		m.message = fmt.Sprintf("Error starting command: %v", err)
+		return nil
+	}
+	c.Command = command
+	p.running = c
+	p.input.SetValue("")
+	p.draft = ""
+
+	m.rememberCommand(p.server.ID, command)
+	p.histIndex = len(m.commandHistory(p.server.ID))
+	if err := m.saveConfig(); err != nil {
+		m.message = fmt.Sprintf("Error saving command history: %v", err)
+	} else {
+		m.message = ""
+	}
+	return waitForCommand(c)
+}
+
+// commandDone shows the result of a finished command
+func (m *model) commandDone(msg commandDoneMsg) {
+	p := m.commandPanel
+	if p == nil || p.running != msg.cmd {
+		return
+	}
+	p.running = nil
+	msg.result.Dir = p.Dir
+	p.results = append(p.results, msg.result)
+	if len(p.results) > commandResultsMax {
+		p.results = p.results[len(p.results)-commandResultsMax:]
+	}
+	p.render()
+}
+
+// browseHistory moves through the server's history by step, keeping what
+// was typed as a draft to return to.
+func (m *model) browseHistory(step int) {
+	p := m.commandPanel
+	history := m.commandHistory(p.server.ID)
+	next := p.histIndex + step
+	if next < 0 || next > len(history) {
+		return
+	}
+	if p.histIndex == len(history) {
+		p.draft = p.input.Value()
+	}
+	p.histIndex = next
+	if next == len(history) {
+		p.input.SetValue(p.draft)
+	} else {
+		p.input.SetValue(history[next])
+	}
+	p.input.CursorEnd()
+}
+
+func (m model) updateCommandView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	p := m.commandPanel
+	switch msg.String() {
+	case "ctrl+c", "esc":
+		if p.running != nil {
+			p.running.Cancel()
+			m.message = "Command cancelled"
+			return m, nil
+		}
+		m.commandPanel = nil
+		m.state = sftpView
+		m.loadLocalFiles(m.localPath)
+		m.loadRemoteFiles(m.remotePath)
+		return m, nil
+
+	case "enter":
+		cmd := m.runCommand()
+		return m, cmd
+
+	case "up":
+		m.browseHistory(-1)
+		return m, nil
+
+	case "down":
+		m.browseHistory(1)
+		return m, nil
+
+	case "ctrl+l":
+		p.results = nil
+		p.render()
+		return m, nil
+
+	case "pgup", "pgdown":
+		var cmd tea.Cmd
+		p.viewport, cmd = p.viewport.Update(msg)
+		return m, cmd
+	}
+
+	var cmd tea.Cmd
+	p.input, cmd = p.input.Update(msg)
+	return m, cmd
+}
+
+func (m model) viewCommand() string {
+	p := m.commandPanel
+	var b strings.Builder
+	b.WriteString(titleStyle.Render(fmt.Sprintf("Run on %s", p.server.Name)) + "\n")
+	b.WriteString(helpStyle.Render(fmt.Sprintf("%s@%s:%s", p.server.Username, p.server.Host, p.Dir)) + "\n\n")
+	b.WriteString(p.viewport.View() + "\n\n")
+
+	if p.running != nil {
+		b.WriteString(messageStyle.Render(fmt.Sprintf("Running %s...", p.running.Command)) + "\n")
+		b.WriteString(helpStyle.Render("[esc] cancel"))
+	} else {
+		b.WriteString("  " + p.input.View() + "\n")
+		b.WriteString(helpStyle.Render("[enter] run • [↑/↓] history • [pgup/pgdown] scroll • [ctrl+l] clear • [esc] back"))
+	}
+
+	if m.message != "" {
+		msgStyle := messageStyle
+		if strings.HasPrefix(m.message, "Error") {
+			msgStyle = errorStyle
+		}
+		b.WriteString("\n" + msgStyle.Render(m.message))
+	}
+	return b.String()
+}
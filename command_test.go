@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/textinput"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		writes        []int // sizes of the writes
+		wantLen       int
+		wantTruncated bool
+	}{
+		{name: "small", writes: []int{10, 20}, wantLen: 30},
+		{name: "exactly full", writes: []int{commandOutputMax}, wantLen: commandOutputMax},
+		{name: "one write too many", writes: []int{commandOutputMax - 5, 10}, wantLen: commandOutputMax, wantTruncated: true},
+		{name: "write past full", writes: []int{commandOutputMax, 1, 100}, wantLen: commandOutputMax, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b cappedBuffer
+			for _, size := range tt.writes {
+				// Callers like io.Copy fail on short writes
+				if n, err := b.Write(bytes.Repeat([]byte("x"), size)); n != size || err != nil {
+					t.Fatalf("Write(%d bytes) = %d, %v", size, n, err)
+				}
+			}
+			if b.Len() != tt.wantLen || b.truncated != tt.wantTruncated {
+				t.Errorf("kept %d bytes, truncated %v; want %d, %v", b.Len(), b.truncated, tt.wantLen, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestCommandResultOutcome(t *testing.T) {
+	tests := []struct {
+		name   string
+		result commandResult
+		want   string
+		wantOK bool
+	}{
+		{name: "success", result: commandResult{}, want: "exit 0", wantOK: true},
+		{name: "exit status", result: commandResult{Status: 2}, want: "exit 2"},
+		{name: "no exit status", result: commandResult{Status: -1}, want: "ended without exit status"},
+		{name: "error", result: commandResult{Status: -1, Err: errors.New("connection lost")}, want: "failed: connection lost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.Outcome(); got != tt.want {
+				t.Errorf("Outcome() = %q, want %q", got, tt.want)
+			}
+			if got := tt.result.OK(); got != tt.wantOK {
+				t.Errorf("OK() = %v, want %v", got, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCommandResultOutput(t *testing.T) {
+	res := commandResult{Stdout: "a\x1b[2Jb\n", Stderr: "oops\n"}
+	got := res.output()
+	if strings.Contains(got, "\x1b[2J") || !strings.Contains(got, `a\x1b[2Jb`) {
+		t.Errorf("output() = %q, want the escape sequence escaped", got)
+	}
+	if !strings.Contains(got, "oops") {
+		t.Errorf("output() = %q, want stderr", got)
+	}
+}
+
+func TestRememberCommand(t *testing.T) {
+	m := model{config: &Config{}}
+	for _, c := range []string{"ls", "df -h", "ls", "uptime"} {
+		m.rememberCommand(1, c)
+	}
+	m.rememberCommand(2, "ls")
+	if got := strings.Join(m.commandHistory(1), ","); got != "df -h,ls,uptime" {
+		t.Errorf("history = %q, want repeats moved to the end", got)
+	}
+	if got := strings.Join(m.commandHistory(2), ","); got != "ls" {
+		t.Errorf("other server's history = %q", got)
+	}
+
+	for i := 0; i < commandHistoryMax+5; i++ {
+		m.rememberCommand(1, fmt.Sprintf("echo %d", i))
+	}
+	history := m.commandHistory(1)
+	if len(history) != commandHistoryMax {
+		t.Fatalf("kept %d commands, want %d", len(history), commandHistoryMax)
+	}
+	if want := fmt.Sprintf("echo %d", commandHistoryMax+4); history[len(history)-1] != want {
+		t.Errorf("newest = %q, want %q", history[len(history)-1], want)
+	}
+	if history[0] != "echo 5" {
+		t.Errorf("oldest = %q, want the oldest dropped first", history[0])
+	}
+}
+
+func TestBrowseHistory(t *testing.T) {
+	m := model{config: &Config{CommandHistory: map[int][]string{1: {"ls", "df -h"}}}}
+	m.commandPanel = &commandPanel{server: &Server{ID: 1}, input: textinput.New(), histIndex: 2}
+	m.commandPanel.input.SetValue("draft")
+
+	steps := []struct {
+		step int
+		want string
+	}{
+		{-1, "df -h"},
+		{-1, "ls"},
+		{-1, "ls"}, // stays at the oldest
+		{1, "df -h"},
+		{1, "draft"},
+		{1, "draft"}, // stays at the draft
+	}
+	for i, s := range steps {
+		m.browseHistory(s.step)
+		if got := m.commandPanel.input.Value(); got != s.want {
+			t.Errorf("step %d: input = %q, want %q", i, got, s.want)
+		}
+	}
+}
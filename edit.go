@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// remoteEdit is a remote file being edited through a local copy
+type remoteEdit struct {
+	fsys      fileSystem
+	Path      string // file on the server
+	Name      string
+	LocalPath string // copy in a private temp dir
+	TempDir   string
+	ModTime   time.Time // remote state when the copy was made
+	Size      int64
+	Mode      os.FileMode
+	Sum       [32]byte // checksum of the copy before editing
+	Gone      bool     // the remote file was deleted while editing
+}
+
+// editFetchedMsg is sent when the copy of a remote file is ready to edit
+type editFetchedMsg struct {
+	edit *remoteEdit
+	name string
+	err  error
+}
+
+// editFinishedMsg is sent when the editor exits
+type editFinishedMsg struct {
+	edit *remoteEdit
+	err  error
+}
+
+// editSavedMsg is sent when an edited copy has been uploaded to path
+type editSavedMsg struct {
+	edit *remoteEdit
+	path string
+	err  error
+}
+
+// editorCommand returns the user's editor with its arguments, from
+// $VISUAL or $EDITOR, and vi if neither is set.
+func editorCommand(file string) *exec.Cmd {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		args = []string{"vi"}
+	}
+	return exec.Command(args[0], append(args[1:], file)...)
+}
+
+// fetchForEdit copies a remote file into a new temp dir that only the user
+// can read.
+func fetchForEdit(fsys fileSystem, path, name string) (*remoteEdit, error) {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	}
+
+	dir, err := os.MkdirTemp("", "termius-edit-")
+	if err != nil {
+		return nil, err
+	}
+	edit := &remoteEdit{fsys: fsys, Path: path, Name: name, LocalPath: filepath.Join(dir, name), TempDir: dir, ModTime: info.ModTime(), Size: info.Size(), Mode: info.Mode().Perm()}
+
+	in, err := fsys.Open(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(edit.LocalPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	copy(edit.Sum[:], hash.Sum(nil))
+	return edit, nil
+}
+
+// changed reports whether the local copy differs from what was downloaded
+func (e *remoteEdit) changed() (bool, error) {
+	data, err := os.ReadFile(e.LocalPath)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(data)
+	return !bytes.Equal(sum[:], e.Sum[:]), nil
+}
+
+// remoteMoved reports whether the remote file was modified or deleted since
+// the copy was made.
+func (e *remoteEdit) remoteMoved() bool {
+	info, err := e.fsys.Stat(e.Path)
+	if err != nil {
+		e.Gone = true
+		return true
+	}
+	return !info.ModTime().Equal(e.ModTime) || info.Size() != e.Size
+}
+
+// upload writes the local copy to path on the server. An existing file is
+// overwritten in place, so a symlink keeps pointing at it and its owner,
+// permissions and hard links are kept; only the file itself has to be
+// writable. A file that no longer exists is created with the permissions
+// the original had. If the upload fails the local copy is kept.
+func (e *remoteEdit) upload(path string) error {
+	_, statErr := e.fsys.Stat(path)
+
+	in, err := os.Open(e.LocalPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := e.fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || statErr == nil {
+		return err
+	}
+	return e.fsys.Chmod(path, e.Mode)
+}
+
+func (e *remoteEdit) cleanup() {
+	os.RemoveAll(e.TempDir)
+}
+
+// startEdit opens the selected file of the focused pane in the editor.
+// Local files are edited in place; remote ones through a temporary copy
+// that is uploaded when the editor exits.
+func (m *model) startEdit() tea.Cmd {
+	fsys, dir := m.paneFS()
+	_, l := m.paneMarks()
+	sel, ok := l.SelectedItem().(fileEntry)
+	if fsys == nil || !ok || sel.Name == "../" {
+		return nil
+	}
+	if sel.IsDir() || sel.LinkDir {
+		m.message = fmt.Sprintf("Error: %s is a directory", sel.Name)
+		return nil
+	}
+	name := sel.Name
+	path := fsys.Join(dir, name)
+
+	if _, ok := fsys.(localFS); ok {
+		return tea.ExecProcess(editorCommand(path), func(err error) tea.Msg {
+			return editFinishedMsg{err: err}
+		})
+	}
+
+	m.message = fmt.Sprintf("Downloading %s for editing...", name)
+	return func() tea.Msg {
+		edit, err := fetchForEdit(fsys, path, name)
+		return editFetchedMsg{edit: edit, name: name, err: err}
+	}
+}
+
+// editFetched opens the editor on a downloaded copy
+func (m *model) editFetched(msg editFetchedMsg) tea.Cmd {
+	if msg.err != nil {
+		m.message = fmt.Sprintf("Error opening %s: %v", msg.name, msg.err)
+		return nil
+	}
+	if m.state != sftpView {
+		// The SFTP view was left while downloading
+		msg.edit.cleanup()
+		return nil
+	}
+	m.message = ""
+	edit := msg.edit
+	return tea.ExecProcess(editorCommand(edit.LocalPath), func(err error) tea.Msg {
+		return editFinishedMsg{edit: edit, err: err}
+	})
+}
+
+// editFinished uploads an edited copy if it changed. If the remote file
+// was changed too, the user is asked what to do with the edit.
+func (m *model) editFinished(msg editFinishedMsg) tea.Cmd {
+	edit := msg.edit
+	if msg.err != nil {
+		m.message = fmt.Sprintf("Error running editor: %v", msg.err)
+		if edit != nil {
+			edit.cleanup()
+		}
+		return nil
+	}
+	if edit == nil {
+		m.reloadPane()
+		return nil
+	}
+
+	changed, err := edit.changed()
+	if err != nil {
+		m.message = fmt.Sprintf("Error reading edited %s: %v", edit.Name, err)
+		edit.cleanup()
+		return nil
+	}
+	if !changed {
+		m.message = fmt.Sprintf("No changes to %s", edit.Name)
+		edit.cleanup()
+		return nil
+	}
+	if edit.remoteMoved() {
+		m.pendingEdit = edit
+		return nil
+	}
+	return m.saveEdit(edit, edit.Path)
+}
+
+// saveEdit uploads the edited copy to path in the background
+func (m *model) saveEdit(edit *remoteEdit, path string) tea.Cmd {
+	m.message = fmt.Sprintf("Uploading %s...", edit.Name)
+	return func() tea.Msg {
+		return editSavedMsg{edit: edit, path: path, err: edit.upload(path)}
+	}
+}
+
+// editSaved removes the uploaded copy, or keeps it if the upload failed
+func (m *model) editSaved(msg editSavedMsg) {
+	edit := msg.edit
+	if msg.err != nil {
+		m.message = fmt.Sprintf("Error uploading %s: %v (edited copy kept at %s)", edit.Name, msg.err, edit.LocalPath)
+		return
+	}
+	edit.cleanup()
+	m.message = fmt.Sprintf("Saved %s", msg.path)
+	if m.state == sftpView {
+		m.loadLocalFiles(m.localPath)
+		m.loadRemoteFiles(m.remotePath)
+	}
+}
+
+func (m model) updateEditConflict(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	edit := m.pendingEdit
+	switch msg.String() {
+	case "o", "O":
+		m.pendingEdit = nil
+		return m, m.saveEdit(edit, edit.Path)
+	case "s", "S":
+		m.pendingEdit = nil
+		return m, m.saveEdit(edit, edit.Path+".edited")
+	case "esc", "q":
+		m.pendingEdit = nil
+		m.message = fmt.Sprintf("Upload cancelled, edited copy kept at %s", edit.LocalPath)
+	}
+	return m, nil
+}
+
+func (m model) viewEditConflict() string {
+	edit := m.pendingEdit
+	question := fmt.Sprintf("%s changed on the server while you were editing it.", edit.Name)
+	if edit.Gone {
+		question = fmt.Sprintf("%s was deleted on the server while you were editing it.", edit.Name)
+	}
+	return errorStyle.Render(question) + "\n" + helpStyle.Render(fmt.Sprintf("[o]verwrite • [s]ave as %s.edited • [esc] keep the local copy only", edit.Name))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fetchTestEdit writes content to dir/name and fetches it for editing
+func fetchTestEdit(t *testing.T, dir, name, content string, mode os.FileMode) *remoteEdit {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	edit, err := fetchForEdit(localFS{}, path, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(edit.cleanup)
+	return edit
+}
+
+func TestRemoteEditUpload(t *testing.T) {
+	const edited = "edited\n"
+
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, dir string) *remoteEdit
+		target   func(e *remoteEdit) string // where the edit is saved
+		check    string                     // another path that must show the edit
+		symlink  bool                       // the edited path must stay a symlink
+		wantMode os.FileMode
+	}{
+		{
+			name:     "in place",
+			setup:    func(t *testing.T, dir string) *remoteEdit { return fetchTestEdit(t, dir, "app.conf", "old\n", 0640) },
+			wantMode: 0640,
+		},
+		{
+			name: "hard link",
+			setup: func(t *testing.T, dir string) *remoteEdit {
+				e := fetchTestEdit(t, dir, "app.conf", "old\n", 0640)
+				if err := os.Link(e.Path, filepath.Join(dir, "link")); err != nil {
+					t.Fatal(err)
+				}
+				return e
+			},
+			check:    "link",
+			wantMode: 0640,
+		},
+		{
+			name: "symlink",
+			setup: func(t *testing.T, dir string) *remoteEdit {
+				fetchTestEdit(t, dir, "real.conf", "old\n", 0644)
+				if err := os.Symlink("real.conf", filepath.Join(dir, "app.conf")); err != nil {
+					t.Fatal(err)
+				}
+				e, err := fetchForEdit(localFS{}, filepath.Join(dir, "app.conf"), "app.conf")
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(e.cleanup)
+				return e
+			},
+			check:    "real.conf",
+			symlink:  true,
+			wantMode: 0644,
+		},
+		{
+			name: "read-only directory",
+			setup: func(t *testing.T, dir string) *remoteEdit {
+				e := fetchTestEdit(t, dir, "app.conf", "old\n", 0600)
+				if err := os.Chmod(dir, 0555); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.Chmod(dir, 0755) })
+				return e
+			},
+			wantMode: 0600,
+		},
+		{
+			name: "deleted while editing",
+			setup: func(t *testing.T, dir string) *remoteEdit {
+				e := fetchTestEdit(t, dir, "app.conf", "old\n", 0750)
+				if err := os.Remove(e.Path); err != nil {
+					t.Fatal(err)
+				}
+				return e
+			},
+			wantMode: 0750,
+		},
+		{
+			name:     "saved alongside",
+			setup:    func(t *testing.T, dir string) *remoteEdit { return fetchTestEdit(t, dir, "app.conf", "old\n", 0640) },
+			target:   func(e *remoteEdit) string { return e.Path + ".edited" },
+			check:    "app.conf.edited",
+			wantMode: 0640,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			e := tt.setup(t, dir)
+			if err := os.WriteFile(e.LocalPath, []byte(edited), 0600); err != nil {
+				t.Fatal(err)
+			}
+			target := e.Path
+			if tt.target != nil {
+				target = tt.target(e)
+			}
+
+			if err := e.upload(target); err != nil {
+				t.Fatal(err)
+			}
+			check := target
+			if tt.check != "" {
+				check = filepath.Join(dir, tt.check)
+			}
+			if got, err := os.ReadFile(check); err != nil || string(got) != edited {
+				t.Errorf("%s = %q, %v; want %q", tt.check, got, err, edited)
+			}
+			info, err := os.Lstat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.symlink {
+				if info.Mode()&os.ModeSymlink == 0 {
+					t.Error("symlink replaced by a file")
+				}
+				info, _ = os.Stat(target)
+			}
+			if info.Mode().Perm() != tt.wantMode {
+				t.Errorf("mode = %v, want %v", info.Mode().Perm(), tt.wantMode)
+			}
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				if isPartial(entry.Name()) {
+					t.Errorf("left %s behind", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteEditConflict(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		change   func(t *testing.T, path string)
+		want     bool
+		wantGone bool
+	}{
+		{name: "unchanged", change: func(t *testing.T, path string) {}},
+		{
+			name: "touched",
+			change: func(t *testing.T, path string) {
+				if err := os.Chtimes(path, later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{
+			name: "rewritten",
+			change: func(t *testing.T, path string) {
+				info, _ := os.Stat(path)
+				if err := os.WriteFile(path, []byte("other content\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				os.Chtimes(path, info.ModTime(), info.ModTime())
+			},
+			want: true,
+		},
+		{
+			name: "deleted",
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     true,
+			wantGone: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := fetchTestEdit(t, t.TempDir(), "app.conf", "old\n", 0644)
+			tt.change(t, e.Path)
+			if got := e.remoteMoved(); got != tt.want || e.Gone != tt.wantGone {
+				t.Errorf("remoteMoved() = %v, Gone %v; want %v, %v", got, e.Gone, tt.want, tt.wantGone)
+			}
+		})
+	}
+}
+
+func TestRemoteEditChanged(t *testing.T) {
+	e := fetchTestEdit(t, t.TempDir(), "app.conf", "old\n", 0644)
+	if changed, err := e.changed(); err != nil || changed {
+		t.Errorf("changed() before editing = %v, %v", changed, err)
+	}
+	if err := os.WriteFile(e.LocalPath, []byte("new\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := e.changed(); err != nil || !changed {
+		t.Errorf("changed() after editing = %v, %v", changed, err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+// fileEntry is one row of an SFTP pane
+type fileEntry struct {
+	Name    string // directories end in "/"; the parent is "../"
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	Owner   string
+	Link    string // symlink target, if the entry is a symlink
+	LinkDir bool   // the symlink resolves to a directory
+}
+
+func (e fileEntry) FilterValue() string { return e.Name }
+func (e fileEntry) IsDir() bool         { return strings.HasSuffix(e.Name, "/") }
+func (e fileEntry) IsLink() bool        { return e.Mode&os.ModeSymlink != 0 }
+
+// browseName is the name to navigate by: symlinks to directories are
+// entered like directories.
+func (e fileEntry) browseName() string {
+	if e.LinkDir {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+var parentEntry = fileEntry{Name: "../", Mode: os.ModeDir}
+
+// newFileEntry describes info; owner is the display name of its owner
+func newFileEntry(info os.FileInfo, owner string) fileEntry {
+	name := info.Name()
+	if info.IsDir() {
+		name += "/"
+	}
+	return fileEntry{
+		Name:    name,
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		Owner:   owner,
+	}
+}
+
+// listEntries lists dir on fsys. Symlinks are resolved to their target and
+// whether it is a directory, at the cost of a readlink and a stat each.
+func listEntries(fsys fileSystem, dir string) ([]fileEntry, error) {
+	infos, err := fsys.ListFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fileEntry, 0, len(infos))
+	for _, info := range infos {
+		e := newFileEntry(info, fsys.Owner(info))
+		if e.IsLink() {
+			linkPath := fsys.Join(dir, info.Name())
+			e.Link, _ = fsys.Readlink(linkPath)
+			if target, err := fsys.Stat(linkPath); err == nil && target.IsDir() {
+				e.LinkDir = true
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// sortMode orders the entries of the SFTP panes
+type sortMode int
+
+const (
+	sortByName sortMode = iota
+	sortBySize
+	sortByTime
+)
+
+func (s sortMode) String() string {
+	switch s {
+	case sortBySize:
+		return "size"
+	case sortByTime:
+		return "date"
+	}
+	return "name"
+}
+
+// sortEntries orders entries by mode, keeping directories first. Size and
+// date sort largest and newest first; desc reverses the order.
+func sortEntries(entries []fileEntry, mode sortMode, desc bool) {
+	less := func(a, b fileEntry) bool {
+		switch {
+		case mode == sortBySize && a.Size != b.Size:
+			return a.Size > b.Size
+		case mode == sortByTime && !a.ModTime.Equal(b.ModTime):
+			return a.ModTime.After(b.ModTime)
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		aDir, bDir := a.IsDir() || a.LinkDir, b.IsDir() || b.LinkDir
+		if aDir != bDir {
+			return aDir
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+// entryItems sorts entries and turns them into list items, led by the
+// parent entry unless dir is the root.
+func entryItems(entries []fileEntry, dir string, mode sortMode, desc bool) []list.Item {
+	sortEntries(entries, mode, desc)
+	items := make([]list.Item, 0, len(entries)+1)
+	if dir != "/" {
+		items = append(items, parentEntry)
+	}
+	for _, e := range entries {
+		items = append(items, e)
+	}
+	return items
+}
+
+// formatModTime renders a time like ls: recent times with the clock, older
+// ones with the year.
+func formatModTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if time.Since(t) < 180*24*time.Hour && t.Before(time.Now().Add(time.Hour)) {
+		return t.Format("Jan _2 15:04")
+	}
+	return t.Format("Jan _2  2006")
+}
+
+// entryDelegate renders file entries in columns. Columns are dropped from
+// the left as the pane gets narrower: owner, then permissions, then date,
+// then size. The name is always shown.
+type entryDelegate struct {
+	marked markSet
+}
+
+func (d entryDelegate) Height() int                             { return 1 }
+func (d entryDelegate) Spacing() int                            { return 0 }
+func (d entryDelegate) Update(_ tea.Msg, _ *list.Model) tea.Cmd { return nil }
+
+func (d entryDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	e, ok := listItem.(fileEntry)
+	if !ok {
+		return
+	}
+
+	prefix := "  "
+	style := lipgloss.NewStyle()
+	if d.marked[e.Name] {
+		prefix = " *"
+		style = fileMarkedStyle
+	}
+	if index == m.Index() {
+		prefix = ">" + prefix[1:]
+		style = fileSelectedStyle.Copy().UnsetPaddingLeft()
+	}
+	prefix += " "
+
+	width := m.Width() - len(prefix)
+	var cols []string
+	if e.Name != "../" {
+		if width >= 75 {
+			cols = append(cols, fmt.Sprintf("%-8.8s", e.Owner))
+		}
+		if width >= 60 {
+			cols = append(cols, e.Mode.String())
+		}
+		if width >= 45 {
+			cols = append(cols, fmt.Sprintf("%-12s", formatModTime(e.ModTime)))
+		}
+		if width >= 30 {
+			size := ""
+			if !e.IsDir() {
+				size = FormatSize(e.Size)
+			}
+			cols = append(cols, fmt.Sprintf("%9s", size))
+		}
+	}
+
+	name := e.Name
+	if e.IsLink() {
+		name += " -> " + e.Link
+		if e.LinkDir {
+			name += "/"
+		}
+	}
+	line := strings.Join(append(cols, name), " ")
+	if width > 0 {
+		line = runewidth.Truncate(line, width, "…")
+	}
+	fmt.Fprint(w, style.Render(prefix+line))
+}
+
+// markSummary describes the marked entries of a pane for its header
+func markSummary(marks markSet, items []list.Item) string {
+	if len(marks) == 0 {
+		return ""
+	}
+	var size int64
+	for _, item := range items {
+		if e, ok := item.(fileEntry); ok && marks[e.Name] && !e.IsDir() {
+			size += e.Size
+		}
+	}
+	return fmt.Sprintf(" %d marked, %s", len(marks), FormatSize(size))
+}
+
+// setSort changes the order of both panes and re-lists them
+func (m *model) setSort(mode sortMode, desc bool) {
+	m.sftpSort, m.sftpSortDesc = mode, desc
+	m.loadLocalFiles(m.localPath)
+	m.loadRemoteFiles(m.remotePath)
+	order := ""
+	if desc {
+		order = ", reversed"
+	}
+	m.message = fmt.Sprintf("Sorted by %s%s", mode, order)
+}
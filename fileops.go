@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// File operation prompts shown at the bottom of the SFTP view
+const (
+	promptRename = "rename"
+	promptMkdir  = "mkdir"
+	promptChmod  = "chmod"
+	promptChown  = "chown"
+	promptSelect = "select"
+)
+
+// paneFS returns the file system and current directory of the focused pane
+func (m model) paneFS() (fileSystem, string) {
+	if m.focusPane == "remote" {
+		return m.sftpManager, m.remotePath
+	}
+	return m.leftFS(), m.localPath
+}
+
+// reloadPane refreshes the listing of the focused pane
+func (m *model) reloadPane() {
+	if m.focusPane == "remote" {
+		m.loadRemoteFiles(m.remotePath)
+	} else {
+		m.loadLocalFiles(m.localPath)
+	}
+}
+
+// selectedName returns the name of the focused pane's selected entry
+// without the trailing slash of directories, or "" if there is none.
+func (m model) selectedName() string {
+	var sel interface{ FilterValue() string }
+	if m.focusPane == "remote" {
+		sel = m.remoteFileList.SelectedItem()
+	} else {
+		sel = m.localFileList.SelectedItem()
+	}
+	if sel == nil || sel.FilterValue() == "../" {
+		return ""
+	}
+	return strings.TrimSuffix(sel.FilterValue(), "/")
+}
+
+// startFilePrompt opens an inline prompt for a file operation on the
+// focused pane. Rename, chmod and chown apply to the selected entry.
+func (m *model) startFilePrompt(kind string) tea.Cmd {
+	if m.focusPane == "remote" && m.sftpManager == nil {
+		m.message = "Error: SFTP connection lost"
+		return nil
+	}
+
+	target := ""
+	if kind != promptMkdir && kind != promptSelect {
+		target = m.selectedName()
+		if target == "" {
+			m.message = "No file selected"
+			return nil
+		}
+	}
+
+	ti := textinput.New()
+	ti.CharLimit = 255
+	ti.Width = 40
+	switch kind {
+	case promptRename:
+		ti.Prompt = fmt.Sprintf("Rename %s to: ", target)
+		ti.SetValue(target)
+	case promptMkdir:
+		ti.Prompt = "New directory: "
+		ti.Placeholder = "name"
+	case promptChmod:
+		ti.Prompt = fmt.Sprintf("Mode of %s: ", target)
+		ti.Placeholder = "755"
+		fsys, dir := m.paneFS()
+		if info, err := fsys.Lstat(fsys.Join(dir, target)); err == nil {
+			ti.SetValue(fmt.Sprintf("%04o", octalMode(info.Mode())))
+		}
+	case promptChown:
+		ti.Prompt = fmt.Sprintf("Owner of %s: ", target)
+		ti.Placeholder = "user:group"
+	case promptSelect:
+		ti.Prompt = "Mark matching: "
+		ti.Placeholder = "*.log"
+	}
+	ti.CursorEnd()
+
+	m.filePrompt = kind
+	m.filePromptTarget = target
+	m.filePromptInput = ti
+	m.message = ""
+	return m.filePromptInput.Focus()
+}
+
+// updateFilePrompt handles keys while a file operation prompt is open
+func (m model) updateFilePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.filePrompt = ""
+		return m, nil
+	case "enter":
+		value := strings.TrimSpace(m.filePromptInput.Value())
+		if value == "" {
+			m.message = "Error: value cannot be empty"
+			return m, nil
+		}
+		m.applyFilePrompt(value)
+		m.filePrompt = ""
+		m.reloadPane()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.filePromptInput, cmd = m.filePromptInput.Update(msg)
+	return m, cmd
+}
+
+// applyFilePrompt runs the prompted operation and reports the outcome
+func (m *model) applyFilePrompt(value string) {
+	fsys, dir := m.paneFS()
+	target := fsys.Join(dir, m.filePromptTarget)
+
+	switch m.filePrompt {
+	case promptRename:
+		newPath := fsys.Join(dir, value)
+		if newPath == target {
+			return
+		}
+		if _, err := fsys.Lstat(newPath); err == nil {
+			m.message = fmt.Sprintf("Error: %s already exists", value)
+			return
+		}
+		if err := fsys.Rename(target, newPath); err != nil {
+			m.message = fmt.Sprintf("Error renaming: %v", err)
+			return
+		}
+		m.message = fmt.Sprintf("Renamed %s to %s", m.filePromptTarget, value)
+
+	case promptMkdir:
+		newPath := fsys.Join(dir, value)
+		if _, err := fsys.Lstat(newPath); err == nil {
+			m.message = fmt.Sprintf("Error: %s already exists", value)
+			return
+		}
+		if err := fsys.MkdirAll(newPath); err != nil {
+			m.message = fmt.Sprintf("Error creating directory: %v", err)
+			return
+		}
+		m.message = fmt.Sprintf("Created %s/", value)
+
+	case promptChmod:
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || mode > 07777 {
+			m.message = fmt.Sprintf("Error: invalid mode %q, use octal like 755", value)
+			return
+		}
+		if err := fsys.Chmod(target, fileMode(mode)); err != nil {
+			m.message = fmt.Sprintf("Error changing mode: %v", err)
+			return
+		}
+		m.message = fmt.Sprintf("Changed mode of %s to %04o", m.filePromptTarget, mode)
+
+	case promptChown:
+		lookup, _ := fsys.(ownerLookup)
+		uid, gid, err := parseOwner(lookup, value)
+		if err != nil {
+			m.message = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		if err := fsys.Chown(target, uid, gid); err != nil {
+			m.message = fmt.Sprintf("Error changing owner: %v", err)
+			return
+		}
+		m.message = fmt.Sprintf("Changed owner of %s to %s", m.filePromptTarget, value)
+
+	case promptSelect:
+		matched, err := m.markMatching(value)
+		if err != nil {
+			m.message = fmt.Sprintf("Error: invalid pattern %q", value)
+			return
+		}
+		m.message = fmt.Sprintf("Marked %d entries matching %s", matched, value)
+	}
+}
+
+// fileMode converts a numeric chmod mode such as 04755 to an os.FileMode
+func fileMode(mode uint64) os.FileMode {
+	fm := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		fm |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fm |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fm |= os.ModeSticky
+	}
+	return fm
+}
+
+// octalMode is the inverse of fileMode
+func octalMode(fm os.FileMode) uint64 {
+	mode := uint64(fm.Perm())
+	if fm&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if fm&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if fm&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// ownerLookup resolves user and group names on one side of the view
+type ownerLookup interface {
+	LookupUID(name string) (int, error)
+	LookupGID(name string) (int, error)
+}
+
+// parseOwner parses "user", "user:group" or ":group", where each part is a
+// name or a numeric ID. A part that is left out is returned as -1.
+func parseOwner(lookup ownerLookup, spec string) (uid, gid int, err error) {
+	userPart, groupPart, _ := strings.Cut(spec, ":")
+	uid, gid = -1, -1
+
+	if userPart != "" {
+		if uid, err = strconv.Atoi(userPart); err != nil {
+			if lookup == nil {
+				return 0, 0, fmt.Errorf("unknown user %q", userPart)
+			}
+			if uid, err = lookup.LookupUID(userPart); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if groupPart != "" {
+		if gid, err = strconv.Atoi(groupPart); err != nil {
+			if lookup == nil {
+				return 0, 0, fmt.Errorf("unknown group %q", groupPart)
+			}
+			if gid, err = lookup.LookupGID(groupPart); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if uid < 0 && gid < 0 {
+		return 0, 0, fmt.Errorf("invalid owner %q, use user:group", spec)
+	}
+	return uid, gid, nil
+}
+
+func (localFS) LookupUID(name string) (int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func (localFS) LookupGID(name string) (int, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// LookupUID resolves a user name from the server's /etc/passwd
+func (sm *SFTPManager) LookupUID(name string) (int, error) {
+	return sm.lookupID("/etc/passwd", name, "user")
+}
+
+// LookupGID resolves a group name from the server's /etc/group
+func (sm *SFTPManager) LookupGID(name string) (int, error) {
+	return sm.lookupID("/etc/group", name, "group")
+}
+
+// lookupID finds name in a colon-separated database whose third field is
+// the numeric ID, as in /etc/passwd and /etc/group.
+func (sm *SFTPManager) lookupID(db, name, kind string) (int, error) {
+	f, err := sm.client.Open(db)
+	if err != nil {
+		return 0, fmt.Errorf("cannot resolve %s %q: %v", kind, name, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 3 && fields[0] == name {
+			return strconv.Atoi(fields[2])
+		}
+	}
+	return 0, fmt.Errorf("unknown %s %q", kind, name)
+}
+
+// deletePlan is a delete waiting for confirmation
+type deletePlan struct {
+	Pane    string // "local" or "remote"
+	Entries []deleteEntry
+	Stats   transferStats // what the delete will remove
+}
+
+type deleteEntry struct {
+	Path  string
+	Name  string
+	IsDir bool
+}
+
+// deleteMeasuredMsg delivers a measured delete for confirmation
+type deleteMeasuredMsg struct {
+	plan *deletePlan
+	name string // the entry that could not be read
+	err  error
+}
+
+// deleteProgressMsg refreshes the progress of a running delete
+type deleteProgressMsg struct {
+	removed *atomic.Int64
+}
+
+// deleteDoneMsg reports a finished, failed or stopped delete
+type deleteDoneMsg struct {
+	plan    *deletePlan
+	deleted int // entries of the plan deleted completely
+	removed int64
+	err     error
+}
+
+const deleteProgressInterval = 200 * time.Millisecond
+
+// countItems says how many items there are, e.g. "1 item"
+func countItems(n int) string {
+	if n == 1 {
+		return "1 item"
+	}
+	return fmt.Sprintf("%d items", n)
+}
+
+// startDelete measures the marked entries of the focused pane, or the
+// selected one, in the background and then asks for confirmation before
+// deleting them.
+func (m *model) startDelete() tea.Cmd {
+	if m.focusPane == "remote" && m.sftpManager == nil {
+		m.message = "Error: SFTP connection lost"
+		return nil
+	}
+	names := m.selectedNames()
+	if len(names) == 0 {
+		m.message = "No file selected"
+		return nil
+	}
+
+	fsys, dir := m.paneFS()
+	pane := m.focusPane
+	ctx, cancel := context.WithCancel(context.Background())
+	m.deleteCancel = cancel
+	m.message = fmt.Sprintf("Measuring %s...", countItems(len(names)))
+	return func() tea.Msg {
+		plan := &deletePlan{Pane: pane}
+		for _, name := range names {
+			name = strings.TrimSuffix(name, "/")
+			target := fsys.Join(dir, name)
+			info, err := fsys.Lstat(target)
+			if err != nil {
+				return deleteMeasuredMsg{name: name, err: err}
+			}
+
+			entry := deleteEntry{Path: target, Name: name, IsDir: info.IsDir()}
+			if entry.IsDir {
+				stats, err := measureTree(ctx, fsys, target)
+				if err != nil {
+					return deleteMeasuredMsg{name: name, err: err}
+				}
+				plan.Stats.Files += stats.Files
+				plan.Stats.Dirs += stats.Dirs
+				plan.Stats.Bytes += stats.Bytes
+			} else {
+				plan.Stats.Files++
+				plan.Stats.Bytes += info.Size()
+			}
+			plan.Entries = append(plan.Entries, entry)
+		}
+		return deleteMeasuredMsg{plan: plan}
+	}
+}
+
+// deleteMeasured asks for confirmation of a measured delete
+func (m *model) deleteMeasured(msg deleteMeasuredMsg) {
+	m.stopDelete()
+	if m.state != sftpView {
+		return
+	}
+	switch {
+	case errors.Is(msg.err, context.Canceled):
+		m.message = "Delete cancelled"
+	case msg.err != nil:
+		m.message = fmt.Sprintf("Error reading %s: %v", msg.name, msg.err)
+	default:
+		m.pendingDelete = msg.plan
+		m.message = ""
+	}
+}
+
+// stopDelete releases the context of a finished measure or delete
+func (m *model) stopDelete() {
+	if m.deleteCancel != nil {
+		m.deleteCancel()
+		m.deleteCancel = nil
+	}
+	m.deleteRemoved = nil
+}
+
+// updateDeleteRunning handles keys while a delete is measured or runs:
+// only stopping it is possible.
+func (m model) updateDeleteRunning(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "q":
+		m.deleteCancel()
+		m.message = "Stopping delete..."
+	}
+	return m, nil
+}
+
+// updateDeleteConfirm handles keys while a delete waits for confirmation
+func (m model) updateDeleteConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "y", "Y":
+		cmd := m.performDelete()
+		return m, cmd
+	case "n", "N", "esc", "q":
+		m.pendingDelete = nil
+		m.message = "Delete cancelled"
+	}
+	return m, nil
+}
+
+// performDelete removes the confirmed entries in the background,
+// recursively for directories. It stops at the first entry that cannot be
+// deleted.
+func (m *model) performDelete() tea.Cmd {
+	plan := m.pendingDelete
+	m.pendingDelete = nil
+
+	fsys := m.leftFS()
+	if plan.Pane == "remote" {
+		if m.sftpManager == nil {
+			m.message = "Error: SFTP connection lost"
+			return nil
+		}
+		fsys = m.sftpManager
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	removed := new(atomic.Int64)
+	m.deleteCancel, m.deleteRemoved = cancel, removed
+	m.message = fmt.Sprintf("Deleting %s...", countItems(plan.Stats.Files+plan.Stats.Dirs))
+	run := func() tea.Msg {
+		msg := deleteDoneMsg{plan: plan}
+		for _, entry := range plan.Entries {
+			if entry.IsDir {
+				msg.err = removeTree(ctx, fsys, entry.Path, removed)
+			} else if msg.err = ctx.Err(); msg.err == nil {
+				if msg.err = fsys.Remove(entry.Path); msg.err == nil {
+					removed.Add(1)
+				}
+			}
+			if msg.err != nil {
+				break
+			}
+			msg.deleted++
+		}
+		msg.removed = removed.Load()
+		return msg
+	}
+	return tea.Batch(run, deleteProgressTick(removed))
+}
+
+func deleteProgressTick(removed *atomic.Int64) tea.Cmd {
+	return tea.Tick(deleteProgressInterval, func(time.Time) tea.Msg {
+		return deleteProgressMsg{removed: removed}
+	})
+}
+
+// deleteProgress shows how far a running delete got
+func (m *model) deleteProgress(msg deleteProgressMsg) tea.Cmd {
+	if msg.removed != m.deleteRemoved || m.deleteCancel == nil {
+		// The delete finished
+		return nil
+	}
+	if m.message != "Stopping delete..." {
+		m.message = fmt.Sprintf("Deleting... %d items removed", msg.removed.Load())
+	}
+	return deleteProgressTick(msg.removed)
+}
+
+// deleteDone reports a finished delete and refreshes its pane
+func (m *model) deleteDone(msg deleteDoneMsg) {
+	m.stopDelete()
+	plan := msg.plan
+	if plan.Pane == "remote" {
+		m.remoteMarked.clear()
+	} else {
+		m.localMarked.clear()
+	}
+	if m.state == sftpView {
+		if plan.Pane == "remote" {
+			m.loadRemoteFiles(m.remotePath)
+		} else {
+			m.loadLocalFiles(m.localPath)
+		}
+	}
+
+	items := plan.Stats.Files + plan.Stats.Dirs
+	switch {
+	case errors.Is(msg.err, context.Canceled):
+		m.message = fmt.Sprintf("Delete stopped after removing %d of %d items", msg.removed, items)
+	case msg.err != nil && msg.deleted > 0:
+		m.message = fmt.Sprintf("Error deleting after %d of %d items: %v", msg.deleted, len(plan.Entries), msg.err)
+	case msg.err != nil:
+		m.message = fmt.Sprintf("Error deleting: %v", msg.err)
+	case len(plan.Entries) > 1:
+		m.message = fmt.Sprintf("Deleted %d items (%s)", msg.deleted, plan.Stats)
+	case plan.Entries[0].IsDir:
+		m.message = fmt.Sprintf("Deleted %s/ (%s)", plan.Entries[0].Name, plan.Stats)
+	default:
+		m.message = fmt.Sprintf("Deleted %s", plan.Entries[0].Name)
+	}
+}
+
+func (m model) viewDeleteConfirm() string {
+	plan := m.pendingDelete
+	var question string
+	if entry := plan.Entries[0]; len(plan.Entries) == 1 && !entry.IsDir {
+		question = fmt.Sprintf("Delete %s %s (%s)?", plan.Pane, entry.Name, FormatSize(plan.Stats.Bytes))
+	} else {
+		what := fmt.Sprintf("%d marked %s entries and everything in them", len(plan.Entries), plan.Pane)
+		if len(plan.Entries) == 1 {
+			what = fmt.Sprintf("%s directory %s/ and everything in it", plan.Pane, entry.Name)
+		}
+		items := plan.Stats.Files + plan.Stats.Dirs
+		question = fmt.Sprintf("Delete %s: %d items, %s?", what, items, FormatSize(plan.Stats.Bytes))
+	}
+	return errorStyle.Render(question) + "\n" + helpStyle.Render("[y] delete • [n] cancel")
+}
+
+func (m model) viewFilePrompt() string {
+	return m.filePromptInput.View() + "\n" + helpStyle.Render("[enter] apply • [esc] cancel")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newLocalPaneModel returns an SFTP view model whose focused left pane
+// lists dir on the local disk
+func newLocalPaneModel(t *testing.T, dir string) model {
+	t.Helper()
+	m := model{
+		state:        sftpView,
+		focusPane:    "local",
+		localMarked:  markSet{},
+		remoteMarked: markSet{},
+	}
+	m.localFileList = list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	m.loadLocalFiles(dir)
+	return m
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name        string
+		marks       []string
+		stop        bool // press esc while deleting
+		wantMessage string
+		wantLeft    []string
+	}{
+		{
+			name:        "directory",
+			marks:       []string{"logs/"},
+			wantMessage: "Deleted logs/ (1 files in 2 dirs, 5 B)",
+			wantLeft:    []string{"app.conf", "notes.txt"},
+		},
+		{
+			name:        "marked",
+			marks:       []string{"logs/", "app.conf"},
+			wantMessage: "Deleted 2 items",
+			wantLeft:    []string{"notes.txt"},
+		},
+		{
+			name:        "stopped",
+			marks:       []string{"logs/", "app.conf"},
+			stop:        true,
+			wantMessage: "Delete stopped after removing 0 of 4 items",
+			wantLeft:    []string{"app.conf", "logs", "notes.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "logs", "old"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range map[string]string{"app.conf": "conf", "notes.txt": "notes", "logs/old/a.log": "hello"} {
+				if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := newLocalPaneModel(t, dir)
+			for _, name := range tt.marks {
+				m.localMarked[name] = true
+			}
+
+			cmd := m.startDelete()
+			if cmd == nil || m.deleteCancel == nil {
+				t.Fatalf("startDelete() did not start measuring: %s", m.message)
+			}
+			next, _ := m.Update(cmd())
+			m = next.(model)
+			if m.pendingDelete == nil {
+				t.Fatalf("no delete to confirm: %s", m.message)
+			}
+
+			next, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+			m = next.(model)
+			if cmd == nil || m.deleteCancel == nil {
+				t.Fatalf("delete did not start: %s", m.message)
+			}
+			if tt.stop {
+				next, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+				m = next.(model)
+				if m.state != sftpView {
+					t.Fatal("esc left the SFTP view while deleting")
+				}
+			}
+			var done tea.Msg
+			for _, c := range cmd().(tea.BatchMsg) {
+				if msg, ok := c().(deleteDoneMsg); ok {
+					done = msg
+				}
+			}
+			next, _ = m.Update(done)
+			m = next.(model)
+
+			if !strings.HasPrefix(m.message, tt.wantMessage) {
+				t.Errorf("message = %q, want %q", m.message, tt.wantMessage)
+			}
+			if m.deleteCancel != nil || len(m.localMarked) != 0 {
+				t.Error("delete state not cleared")
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var left []string
+			for _, e := range entries {
+				left = append(left, e.Name())
+			}
+			if strings.Join(left, " ") != strings.Join(tt.wantLeft, " ") {
+				t.Errorf("left %q, want %q", left, tt.wantLeft)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	followInterval     = time.Second
+	followInitialBytes = 64 << 10 // how much of the end of the file is shown at first
+	followMaxRead      = 1 << 20  // bytes read per poll, so a fast writer cannot stall the view
+	followMaxLines     = 5000     // lines kept in the buffer
+)
+
+var followHighlightStyle = lipgloss.NewStyle().Background(lipgloss.Color("58")).Bold(true)
+
+// tailReader reads what is appended to a file. It keeps the file open, so
+// a rotated log is noticed when the path no longer leads to the open file.
+// Only one poll may run at a time.
+type tailReader struct {
+	fsys   fileSystem
+	path   string
+	f      fsFile
+	offset int64
+}
+
+// statter is implemented by open local and SFTP files
+type statter interface {
+	Stat() (os.FileInfo, error)
+}
+
+// open opens the file and positions the reader fromEnd bytes before its
+// end, at the start of a line.
+func (t *tailReader) open(fromEnd int64) ([]byte, error) {
+	f, err := t.fsys.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Start one byte early: if it is a newline, the offset starts a line
+	t.f, t.offset = f, size-fromEnd-1
+	if t.offset < 0 {
+		t.offset = 0
+	}
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := t.read()
+	if err != nil || t.offset-int64(len(data)) == 0 || len(data) == 0 {
+		return data, err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[i+1:], nil
+	}
+	return data[1:], nil
+}
+
+// read returns the bytes after the offset
+func (t *tailReader) read() ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(t.f, followMaxRead))
+	t.offset += int64(len(data))
+	return data, err
+}
+
+// poll returns the bytes appended since the last poll. If the file was
+// truncated or replaced, rotated is set and reading starts over from the
+// beginning of the new file, after what was left of the old one.
+func (t *tailReader) poll() (data []byte, rotated bool, err error) {
+	if t.f == nil {
+		data, err = t.open(0)
+		return data, err == nil, err
+	}
+
+	// The open file is looked at before and after the path, so a path that
+	// still leads to it can only show a state in between.
+	var before, after os.FileInfo
+	s, _ := t.f.(statter)
+	if s != nil {
+		before, _ = s.Stat()
+	}
+	current, statErr := t.fsys.Stat(t.path)
+	if s != nil {
+		after, _ = s.Stat()
+	}
+	if data, err = t.read(); err != nil {
+		return nil, false, err
+	}
+	if statErr != nil {
+		// Moved away and not recreated yet
+		return data, false, nil
+	}
+
+	// Locally the inode tells for sure. SFTP has no inodes, but an appended
+	// file only grows and gets newer, so a path whose size or modification
+	// time lies outside the two looks at the open file leads elsewhere.
+	var replaced bool
+	if _, local := t.fsys.(localFS); local && after != nil {
+		replaced = !os.SameFile(after, current)
+	} else if before != nil && after != nil {
+		replaced = !statBetween(before, current, after)
+	}
+	switch {
+	case replaced:
+		t.close()
+		more, err := t.open(current.Size())
+		return append(data, more...), true, err
+	case current.Size() < t.offset:
+		if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+			return data, true, err
+		}
+		t.offset = 0
+		more, err := t.read()
+		return append(data, more...), true, err
+	}
+	return data, false, nil
+}
+
+// statBetween reports whether info could be a state of a file that was
+// first seen as before and later as after.
+func statBetween(before, info, after os.FileInfo) bool {
+	return info.Size() >= before.Size() && info.Size() <= after.Size() &&
+		!info.ModTime().Before(before.ModTime()) && !info.ModTime().After(after.ModTime())
+}
+
+func (t *tailReader) close() {
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+	}
+}
+
+// logFollower is the state of the follow view
+type logFollower struct {
+	Path      string
+	reader    *tailReader
+	polling   bool // a poll is running and owns reader
+	gen       int  // tells the polls of this follower from older ones
+	lines     []string
+	partial   string // last line, until its newline arrives
+	Paused    bool
+	unseen    int // lines received while paused
+	Rotations int
+	Filter    *regexp.Regexp // only lines matching are shown
+	Highlight *regexp.Regexp
+	prompt    string // "filter" or "highlight" while typing one
+	input     textinput.Model
+	viewport  viewport.Model
+}
+
+type followTickMsg struct{ gen int }
+
+type followDataMsg struct {
+	gen     int
+	reader  *tailReader
+	data    []byte
+	rotated bool
+	err     error
+}
+
+func followTick(gen int) tea.Cmd {
+	return tea.Tick(followInterval, func(time.Time) tea.Msg {
+		return followTickMsg{gen: gen}
+	})
+}
+
+func pollFollower(gen int, r *tailReader, initial bool) tea.Cmd {
+	return func() tea.Msg {
+		if initial {
+			data, err := r.open(followInitialBytes)
+			return followDataMsg{gen: gen, reader: r, data: data, err: err}
+		}
+		data, rotated, err := r.poll()
+		return followDataMsg{gen: gen, reader: r, data: data, rotated: rotated, err: err}
+	}
+}
+
+// add appends data to the buffer, dropping the oldest lines over the cap
+func (f *logFollower) add(data []byte) int {
+	text := f.partial + strings.ReplaceAll(string(data), "\r\n", "\n")
+	parts := strings.Split(text, "\n")
+	f.partial = parts[len(parts)-1]
+	added := parts[:len(parts)-1]
+	for _, line := range added {
+		f.lines = append(f.lines, followLine(line))
+	}
+	if over := len(f.lines) - followMaxLines; over > 0 {
+		f.lines = append([]string(nil), f.lines[over:]...)
+	}
+	return len(added)
+}
+
+// followLine prepares a log line for display: control characters are
+// escaped and tabs expanded.
+func followLine(line string) string {
+	return strings.ReplaceAll(escapeControl(line), "\t", "    ")
+}
+
+// render shows the buffered lines that pass the filter, wrapped to the
+// viewport, with highlight matches (or else filter matches) marked.
+// The view stays at the bottom if it was there.
+func (f *logFollower) render() {
+	atBottom := f.viewport.AtBottom()
+	mark := f.Highlight
+	if mark == nil {
+		mark = f.Filter
+	}
+	width := f.viewport.Width
+	if width < 10 {
+		width = 10
+	}
+
+	var b strings.Builder
+	lines := f.lines
+	if f.partial != "" {
+		lines = append(lines[:len(lines):len(lines)], followLine(f.partial))
+	}
+	for _, line := range lines {
+		if f.Filter != nil && !f.Filter.MatchString(line) {
+			continue
+		}
+		for _, part := range strings.Split(runewidth.Wrap(line, width), "\n") {
+			if mark != nil {
+				part = mark.ReplaceAllStringFunc(part, func(s string) string { return followHighlightStyle.Render(s) })
+			}
+			b.WriteString(part + "\n")
+		}
+	}
+	f.viewport.SetContent(strings.TrimSuffix(b.String(), "\n"))
+	if atBottom {
+		f.viewport.GotoBottom()
+	}
+}
+
+// --- Follow view ---
+
+// startFollow follows the selected file of the focused pane
+func (m *model) startFollow() tea.Cmd {
+	fsys, dir := m.paneFS()
+	_, l := m.paneMarks()
+	sel, ok := l.SelectedItem().(fileEntry)
+	if fsys == nil || !ok || sel.Name == "../" {
+		return nil
+	}
+	if sel.IsDir() || sel.LinkDir {
+		m.message = fmt.Sprintf("Error: %s is a directory", sel.Name)
+		return nil
+	}
+
+	path := fsys.Join(dir, sel.Name)
+	input := textinput.New()
+	input.CharLimit = 256
+	m.followSeq++
+	m.follower = &logFollower{
+		Path:     path,
+		reader:   &tailReader{fsys: fsys, path: path},
+		polling:  true,
+		gen:      m.followSeq,
+		input:    input,
+		viewport: viewport.New(0, 0),
+	}
+	m.resizeFollower()
+	m.state = followView
+	m.message = ""
+	return pollFollower(m.followSeq, m.follower.reader, true)
+}
+
+func (m *model) resizeFollower() {
+	if m.follower == nil {
+		return
+	}
+	m.follower.viewport.Width = m.width - 2
+	m.follower.viewport.Height = m.height - 6
+	if m.follower.viewport.Height < 3 {
+		m.follower.viewport.Height = 3
+	}
+	m.follower.render()
+}
+
+// stopFollow leaves the follow view. The file is closed now, or by the
+// running poll when it returns.
+func (m *model) stopFollow() {
+	if f := m.follower; f != nil && !f.polling {
+		f.reader.close()
+	}
+	m.follower = nil
+	m.state = sftpView
+}
+
+// followData adds the result of a poll and schedules the next one
+func (m *model) followData(msg followDataMsg) tea.Cmd {
+	f := m.follower
+	if f == nil || f.gen != msg.gen {
+		msg.reader.close()
+		return nil
+	}
+	f.polling = false
+	if msg.err != nil {
+		m.message = fmt.Sprintf("Error reading %s: %v", f.Path, msg.err)
+	} else if m.message != "" && strings.HasPrefix(m.message, "Error reading") {
+		m.message = ""
+	}
+	if msg.rotated {
+		f.Rotations++
+	}
+	added := f.add(msg.data)
+	if f.Paused {
+		f.unseen += added
+	} else if len(msg.data) > 0 || msg.rotated {
+		f.render()
+	}
+	return followTick(f.gen)
+}
+
+// followTicked starts the next poll of the current follower
+func (m *model) followTicked(msg followTickMsg) tea.Cmd {
+	f := m.follower
+	if f == nil || f.gen != msg.gen || f.polling {
+		return nil
+	}
+	f.polling = true
+	return pollFollower(f.gen, f.reader, false)
+}
+
+func (m model) updateFollowView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	f := m.follower
+	if f.prompt != "" {
+		switch msg.String() {
+		case "esc":
+			f.prompt = ""
+			f.input.Blur()
+			return m, nil
+		case "enter":
+			var re *regexp.Regexp
+			if value := f.input.Value(); value != "" {
+				var err error
+				if re, err = regexp.Compile(value); err != nil {
+					m.message = fmt.Sprintf("Error: invalid pattern: %v", err)
+					return m, nil
+				}
+			}
+			if f.prompt == "filter" {
+				f.Filter = re
+			} else {
+				f.Highlight = re
+			}
+			f.prompt = ""
+			f.input.Blur()
+			m.message = ""
+			f.render()
+			f.viewport.GotoBottom()
+			return m, nil
+		}
+		var cmd tea.Cmd
+		f.input, cmd = f.input.Update(msg)
+		return m, cmd
+	}
+
+	switch msg.String() {
+	case "ctrl+c", "q", "esc":
+		m.stopFollow()
+		return m, nil
+
+	case " ", "p":
+		f.Paused = !f.Paused
+		if !f.Paused {
+			f.unseen = 0
+			f.render()
+		}
+		return m, nil
+
+	case "/", "h":
+		f.prompt = "filter"
+		value := f.Filter
+		if msg.String() == "h" {
+			f.prompt = "highlight"
+			value = f.Highlight
+		}
+		f.input.Prompt = f.prompt + ": "
+		f.input.SetValue("")
+		if value != nil {
+			f.input.SetValue(value.String())
+		}
+		f.input.CursorEnd()
+		return m, f.input.Focus()
+
+	case "G", "end":
+		f.viewport.GotoBottom()
+		return m, nil
+
+	case "g", "home":
+		f.viewport.GotoTop()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	f.viewport, cmd = f.viewport.Update(msg)
+	return m, cmd
+}
+
+func (m model) viewFollow() string {
+	f := m.follower
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Follow: "+f.Path) + "\n")
+
+	status := fmt.Sprintf("%d lines", len(f.lines))
+	if f.Paused {
+		status = fmt.Sprintf("PAUSED, %d new lines", f.unseen)
+	} else if !f.viewport.AtBottom() {
+		status += " • scrolled back, [G] to follow"
+	}
+	if f.Filter != nil {
+		status += fmt.Sprintf(" • filter /%s/", f.Filter)
+	}
+	if f.Highlight != nil {
+		status += fmt.Sprintf(" • highlight /%s/", f.Highlight)
+	}
+	if f.Rotations > 0 {
+		status += fmt.Sprintf(" • rotated %d×", f.Rotations)
+	}
+	b.WriteString(helpStyle.Render(status) + "\n")
+	b.WriteString(f.viewport.View() + "\n")
+
+	if f.prompt != "" {
+		b.WriteString(f.input.View() + "  " + helpStyle.Render("regexp, empty to clear • [enter] apply • [esc] cancel"))
+	} else {
+		pause := "[space] pause"
+		if f.Paused {
+			pause = "[space] resume"
+		}
+		b.WriteString(helpStyle.Render(pause + " • [/] filter • [h]ighlight • [g/G] top/bottom • [q] close"))
+	}
+
+	if m.message != "" {
+		b.WriteString("\n" + errorStyle.Render(m.message))
+	}
+	return b.String()
+}
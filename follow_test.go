@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// noInodeFS is the local file system without the inode check of poll
+type noInodeFS struct{ localFS }
+
+func TestTailReaderOpen(t *testing.T) {
+	const content = "one\ntwo\nthree\n"
+
+	tests := []struct {
+		name    string
+		fromEnd int64
+		want    string
+	}{
+		{"whole file", 100, content},
+		{"exactly the file", int64(len(content)), content},
+		{"cut inside a line", 8, "three\n"},
+		{"cut at a line start", 6, "three\n"},
+		{"cut at a newline", 7, "three\n"},
+		{"cut at the second line", 10, "two\nthree\n"},
+		{"nothing", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := &tailReader{fsys: localFS{}, path: path}
+			defer r.close()
+			got, err := r.open(tt.fromEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("open(%d) = %q, want %q", tt.fromEnd, got, tt.want)
+			}
+			if r.offset != int64(len(content)) {
+				t.Errorf("offset = %d, want %d", r.offset, len(content))
+			}
+		})
+	}
+}
+
+func TestTailReaderPoll(t *testing.T) {
+	const content = "one\ntwo\n"
+	appendTo := func(t *testing.T, path, s string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		change      func(t *testing.T, path string)
+		want        string
+		wantRotated bool
+	}{
+		{
+			name:   "unchanged",
+			change: func(t *testing.T, path string) {},
+		},
+		{
+			name:   "appended",
+			change: func(t *testing.T, path string) { appendTo(t, path, "three\n") },
+			want:   "three\n",
+		},
+		{
+			name: "truncated",
+			change: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("new\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:        "new\n",
+			wantRotated: true,
+		},
+		{
+			name: "rotated",
+			change: func(t *testing.T, path string) {
+				appendTo(t, path, "last\n")
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte("first of the new file\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:        "last\nfirst of the new file\n",
+			wantRotated: true,
+		},
+		{
+			name: "replaced by a larger file",
+			change: func(t *testing.T, path string) {
+				tmp := path + ".tmp"
+				if err := os.WriteFile(tmp, []byte("a much longer replacement\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Rename(tmp, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:        "a much longer replacement\n",
+			wantRotated: true,
+		},
+		{
+			name: "moved away",
+			change: func(t *testing.T, path string) {
+				appendTo(t, path, "last\n")
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "last\n",
+		},
+	}
+	// SFTP has no inodes: a local file system hidden behind another type
+	// takes the same path through poll
+	for _, fs := range []struct {
+		name string
+		fsys fileSystem
+	}{{"local", localFS{}}, {"no inodes", noInodeFS{}}} {
+		for _, tt := range tests {
+			t.Run(fs.name+"/"+tt.name, func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "app.log")
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				r := &tailReader{fsys: fs.fsys, path: path}
+				defer r.close()
+				if _, err := r.open(followInitialBytes); err != nil {
+					t.Fatal(err)
+				}
+
+				tt.change(t, path)
+				got, rotated, err := r.poll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.want || rotated != tt.wantRotated {
+					t.Errorf("poll() = %q, %v; want %q, %v", got, rotated, tt.want, tt.wantRotated)
+				}
+
+				// Later appends are read from the file now at path
+				if _, err := os.Stat(path); err != nil {
+					return
+				}
+				appendTo(t, path, "more\n")
+				got, rotated, err = r.poll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != "more\n" || rotated {
+					t.Errorf("next poll() = %q, %v; want %q, false", got, rotated, "more\n")
+				}
+			})
+		}
+	}
+}
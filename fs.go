@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// fsFile is an open file on either end of a transfer
+type fsFile interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+}
+
+// fileSystem is the set of file operations shared by the local disk and an
+// SFTP server, so tree operations are written once for both directions.
+type fileSystem interface {
+	ListFiles(path string) ([]os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+	Lstat(path string) (os.FileInfo, error)
+	Open(path string) (fsFile, error)
+	OpenFile(path string, flag int) (fsFile, error)
+	MkdirAll(path string) error
+	Chmod(path string, mode os.FileMode) error
+	Chtimes(path string, atime, mtime time.Time) error
+	Chown(path string, uid, gid int) error // -1 leaves an ID unchanged
+	Rename(oldPath, newPath string) error  // replaces newPath if it exists
+	Readlink(path string) (string, error)
+	Symlink(target, path string) error
+	Remove(path string) error
+	RemoveAll(path string) error
+	// Walk calls fn for root and everything below it without following
+	// symlinks, like filepath.Walk.
+	Walk(root string, fn filepath.WalkFunc) error
+	Join(elem ...string) string
+	// Owner returns the display name of the owner of a listed file
+	Owner(info os.FileInfo) string
+}
+
+// localFS implements fileSystem on the local disk
+type localFS struct{}
+
+func (localFS) ListFiles(path string) ([]os.FileInfo, error) { return ioutil.ReadDir(path) }
+func (localFS) Stat(path string) (os.FileInfo, error)        { return os.Stat(path) }
+func (localFS) Lstat(path string) (os.FileInfo, error)       { return os.Lstat(path) }
+func (localFS) Open(path string) (fsFile, error)             { return os.Open(path) }
+func (localFS) MkdirAll(path string) error                   { return os.MkdirAll(path, 0755) }
+func (localFS) Chmod(path string, mode os.FileMode) error    { return os.Chmod(path, mode) }
+func (localFS) Join(elem ...string) string                   { return filepath.Join(elem...) }
+func (localFS) Rename(oldPath, newPath string) error         { return os.Rename(oldPath, newPath) }
+func (localFS) Remove(path string) error                     { return os.Remove(path) }
+func (localFS) RemoveAll(path string) error                  { return os.RemoveAll(path) }
+func (localFS) Readlink(path string) (string, error)         { return os.Readlink(path) }
+func (localFS) Symlink(target, path string) error            { return os.Symlink(target, path) }
+func (localFS) Owner(info os.FileInfo) string                { return localOwner(info) }
+
+func (localFS) Walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, fn)
+}
+
+func (localFS) OpenFile(path string, flag int) (fsFile, error) {
+	// Private until the copy is done and gets the source's permissions
+	return os.OpenFile(path, flag, 0600)
+}
+
+func (localFS) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+func (localFS) Chown(path string, uid, gid int) error {
+	return os.Chown(path, uid, gid)
+}
+
+// withProgress also writes everything written to w into progress, if set
+func withProgress(w io.Writer, progress io.Writer) io.Writer {
+	if progress == nil {
+		return w
+	}
+	return io.MultiWriter(w, progress)
+}
+
+// transferOptions controls how files are copied
+type transferOptions struct {
+	// Verify compares SHA-256 checksums of source and copy after the size
+	// check, in addition to it.
+	Verify bool
+	// PreserveLinks recreates symlinks at the destination instead of
+	// copying what they point to.
+	PreserveLinks bool
+}
+
+// partSuffix is appended to a destination while it is being written, so an
+// interrupted copy can be resumed from the partial file's size.
+const partSuffix = ".part"
+
+// partInfoSuffix names the file kept next to a partial copy with the size
+// and modification time of its source. A partial copy is only resumed from
+// the same version of the source.
+const partInfoSuffix = ".part-info"
+
+// isPartial reports whether name is a partial copy or its info file
+func isPartial(name string) bool {
+	return strings.HasSuffix(name, partSuffix) || strings.HasSuffix(name, partInfoSuffix)
+}
+
+// partInfo describes the source of a partial copy
+func partInfo(info os.FileInfo) string {
+	return fmt.Sprintf("%d %d\n", info.Size(), info.ModTime().UnixNano())
+}
+
+// readPartInfo returns the contents of a partial copy's info file, or ""
+func readPartInfo(fsys fileSystem, path string) string {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, 64))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// writePartInfo records the source of a partial copy
+func writePartInfo(fsys fileSystem, path string, info os.FileInfo) error {
+	f, err := fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, partInfo(info))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// progressResumer is implemented by progress sinks that count bytes carried
+// over from an earlier, interrupted attempt.
+type progressResumer interface {
+	Resume(n int64)
+}
+
+// transferStats summarizes a tree transfer
+type transferStats struct {
+	Files   int
+	Dirs    int
+	Bytes   int64
+	Links   int // symlinks recreated as symlinks
+	Current int // files already up to date at the destination
+	Skipped int // symlinked directories and special files
+}
+
+func (s transferStats) String() string {
+	summary := fmt.Sprintf("%d files in %d dirs, %s", s.Files, s.Dirs, FormatSize(s.Bytes))
+	if s.Links > 0 {
+		summary += fmt.Sprintf(" (%d links)", s.Links)
+	}
+	if s.Current > 0 {
+		summary += fmt.Sprintf(" (%d up to date)", s.Current)
+	}
+	if s.Skipped > 0 {
+		summary += fmt.Sprintf(" (%d skipped)", s.Skipped)
+	}
+	return summary
+}
+
+// treeSize returns the total size of the regular files below root
+func treeSize(fsys fileSystem, root string) (int64, error) {
+	entries, err := fsys.ListFiles(root)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSymlink != 0 {
+			target, err := fsys.Stat(fsys.Join(root, entry.Name()))
+			if err != nil || !target.Mode().IsRegular() {
+				continue
+			}
+			entry = target
+		}
+		if entry.IsDir() {
+			n, err := treeSize(fsys, fsys.Join(root, entry.Name()))
+			if err != nil {
+				return total, err
+			}
+			total += n
+		} else if entry.Mode().IsRegular() {
+			total += entry.Size()
+		}
+	}
+	return total, nil
+}
+
+// measureTree counts the files, directories and bytes at and below root.
+// Symlinks are counted as files and not followed. It stops with ctx's error
+// when ctx is done.
+func measureTree(ctx context.Context, fsys fileSystem, root string) (transferStats, error) {
+	var stats transferStats
+	err := fsys.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			stats.Dirs++
+		} else {
+			stats.Files++
+			if info.Mode().IsRegular() {
+				stats.Bytes += info.Size()
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// removeTree deletes root and everything below it without following
+// symlinks: files while walking the tree, then the directories from the
+// deepest up. Each removed entry is counted in removed. It stops with ctx's
+// error when ctx is done.
+func removeTree(ctx context.Context, fsys fileSystem, root string, removed *atomic.Int64) error {
+	var dirs []string
+	err := fsys.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if err := fsys.Remove(path); err != nil {
+			return err
+		}
+		removed.Add(1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fsys.Remove(dirs[i]); err != nil {
+			return err
+		}
+		removed.Add(1)
+	}
+	return nil
+}
+
+// copyTree recursively copies the directory srcDir on src to dstDir on dst,
+// preserving the tree structure, permissions and modification times.
+// With opts.PreserveLinks symlinks are recreated as symlinks; otherwise
+// symlinks to files are copied as regular files and symlinked directories
+// are skipped. Special files are always skipped. Files whose copy already has the same size and
+// modification time are left alone, so a retried tree copy picks up where
+// it stopped. Copied bytes are also written to progress if it is not nil.
+// The copy stops with ctx's error when ctx is done.
+func copyTree(ctx context.Context, src fileSystem, srcDir string, dst fileSystem, dstDir string, opts transferOptions, progress io.Writer) (transferStats, error) {
+	var stats transferStats
+
+	info, err := src.Stat(srcDir)
+	if err != nil {
+		return stats, err
+	}
+	if !info.IsDir() {
+		return stats, fmt.Errorf("%s is not a directory", srcDir)
+	}
+
+	err = copyDir(ctx, src, srcDir, dst, dstDir, info, opts, &stats, progress)
+	return stats, err
+}
+
+func copyDir(ctx context.Context, src fileSystem, srcDir string, dst fileSystem, dstDir string, info os.FileInfo, opts transferOptions, stats *transferStats, progress io.Writer) error {
+	if err := dst.MkdirAll(dstDir); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dstDir, err)
+	}
+	stats.Dirs++
+
+	entries, err := src.ListFiles(srcDir)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %v", srcDir, err)
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		srcPath := src.Join(srcDir, entry.Name())
+		dstPath := dst.Join(dstDir, entry.Name())
+
+		if entry.Mode()&os.ModeSymlink != 0 {
+			if opts.PreserveLinks {
+				if err := copySymlink(src, srcPath, dst, dstPath); err != nil {
+					return err
+				}
+				stats.Links++
+				continue
+			}
+			target, err := src.Stat(srcPath)
+			if err != nil || !target.Mode().IsRegular() {
+				stats.Skipped++
+				continue
+			}
+			entry = target
+		}
+
+		switch {
+		case entry.IsDir():
+			if err := copyDir(ctx, src, srcPath, dst, dstPath, entry, opts, stats, progress); err != nil {
+				return err
+			}
+		case upToDate(dst, dstPath, entry):
+			if r, ok := progress.(progressResumer); ok {
+				r.Resume(entry.Size())
+			}
+			stats.Current++
+		case entry.Mode().IsRegular():
+			n, err := copyFileAttrs(ctx, src, srcPath, dst, dstPath, entry, opts, progress)
+			if err != nil {
+				return err
+			}
+			stats.Files++
+			stats.Bytes += n
+		default:
+			stats.Skipped++
+		}
+	}
+
+	// Directory attributes are applied last, since creating the children
+	// updates the directory's modification time.
+	dst.Chmod(dstDir, info.Mode().Perm())
+	dst.Chtimes(dstDir, info.ModTime(), info.ModTime())
+	return nil
+}
+
+// copyFile copies a single file to dstPath. With opts.PreserveLinks a
+// symlink is recreated as a symlink rather than copied as a file.
+func copyFile(ctx context.Context, src fileSystem, srcPath string, dst fileSystem, dstPath string, opts transferOptions, progress io.Writer) error {
+	if opts.PreserveLinks {
+		if info, err := src.Lstat(srcPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return copySymlink(src, srcPath, dst, dstPath)
+		}
+	}
+
+	info, err := src.Stat(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", srcPath, err)
+	}
+	_, err = copyFileAttrs(ctx, src, srcPath, dst, dstPath, info, opts, progress)
+	return err
+}
+
+// copySymlink recreates the symlink srcPath at dstPath with the same target,
+// replacing a file or symlink already there. Targets are copied verbatim,
+// so relative links keep pointing inside the copied tree.
+func copySymlink(src fileSystem, srcPath string, dst fileSystem, dstPath string) error {
+	target, err := src.Readlink(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to read link %s: %v", srcPath, err)
+	}
+	if existing, err := dst.Lstat(dstPath); err == nil {
+		if existing.IsDir() {
+			return fmt.Errorf("cannot replace directory %s with a link", dstPath)
+		}
+		if err := dst.Remove(dstPath); err != nil {
+			return fmt.Errorf("failed to replace %s: %v", dstPath, err)
+		}
+	}
+	if err := dst.Symlink(target, dstPath); err != nil {
+		return fmt.Errorf("failed to create link %s: %v", dstPath, err)
+	}
+	return nil
+}
+
+// upToDate reports whether dstPath is a regular file with the size and
+// modification time of info, as left behind by an earlier complete copy.
+func upToDate(dst fileSystem, dstPath string, info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	existing, err := dst.Stat(dstPath)
+	if err != nil || !existing.Mode().IsRegular() {
+		return false
+	}
+	return existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime())
+}
+
+// copyFileAttrs copies one regular file and applies its permissions and
+// modification time to the copy. The data is written to dstPath+".part"
+// first; if that file exists from an interrupted attempt of the same
+// source, both ends are seeked to its size and the copy continues from
+// there. The partial file replaces dstPath only once its size (and with
+// opts.Verify, its checksum) matches the source. It returns the number of
+// bytes copied by this call.
+func copyFileAttrs(ctx context.Context, src fileSystem, srcPath string, dst fileSystem, dstPath string, info os.FileInfo, opts transferOptions, progress io.Writer) (int64, error) {
+	partPath, infoPath := dstPath+partSuffix, dstPath+partInfoSuffix
+
+	var offset int64
+	if partial, err := dst.Stat(partPath); err == nil && partial.Mode().IsRegular() && partial.Size() <= info.Size() &&
+		readPartInfo(dst, infoPath) == partInfo(info) {
+		offset = partial.Size()
+	}
+
+	in, err := src.Open(srcPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %v", srcPath, err)
+	}
+	defer in.Close()
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		// Start over, also when the source changed since the partial copy
+		flag |= os.O_TRUNC
+		if err := writePartInfo(dst, infoPath, info); err != nil {
+			return 0, fmt.Errorf("failed to create %s: %v", infoPath, err)
+		}
+	}
+	out, err := dst.OpenFile(partPath, flag)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %v", partPath, err)
+	}
+
+	if offset > 0 {
+		if _, err := in.Seek(offset, io.SeekStart); err != nil {
+			out.Close()
+			return 0, fmt.Errorf("failed to resume %s: %v", srcPath, err)
+		}
+		if _, err := out.Seek(offset, io.SeekStart); err != nil {
+			out.Close()
+			return 0, fmt.Errorf("failed to resume %s: %v", partPath, err)
+		}
+		if r, ok := progress.(progressResumer); ok {
+			r.Resume(offset)
+		}
+	}
+
+	n, err := io.Copy(transferWriter(ctx, out, progress), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to copy %s: %v", srcPath, err)
+	}
+
+	if err := verifyCopy(src, srcPath, dst, partPath, info, opts); err != nil {
+		return n, err
+	}
+	if err := dst.Rename(partPath, dstPath); err != nil {
+		return n, fmt.Errorf("failed to rename %s: %v", partPath, err)
+	}
+	dst.Remove(infoPath)
+
+	dst.Chmod(dstPath, info.Mode().Perm())
+	dst.Chtimes(dstPath, info.ModTime(), info.ModTime())
+	return n, nil
+}
+
+// verifyCopy checks a finished partial file against its source. A checksum
+// mismatch discards the partial file, since resuming it cannot fix it.
+func verifyCopy(src fileSystem, srcPath string, dst fileSystem, partPath string, info os.FileInfo, opts transferOptions) error {
+	copied, err := dst.Stat(partPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %v", partPath, err)
+	}
+	if copied.Size() != info.Size() {
+		return fmt.Errorf("size mismatch for %s: copied %d of %d bytes", srcPath, copied.Size(), info.Size())
+	}
+	if !opts.Verify {
+		return nil
+	}
+
+	want, err := fileChecksum(src, srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum %s: %v", srcPath, err)
+	}
+	got, err := fileChecksum(dst, partPath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum %s: %v", partPath, err)
+	}
+	if got != want {
+		dst.Remove(partPath)
+		return fmt.Errorf("checksum mismatch for %s, partial copy discarded", srcPath)
+	}
+	return nil
+}
+
+// fileChecksum returns the hex SHA-256 of a file. File systems that can
+// hash a file in place (a remote sha256sum) do so; others are read through.
+func fileChecksum(fsys fileSystem, path string) (string, error) {
+	if c, ok := fsys.(interface {
+		Checksum(path string) (string, error)
+	}); ok {
+		if sum, err := c.Checksum(path); err == nil {
+			return sum, nil
+		}
+	}
+	return hashFile(fsys, path)
+}
+
+// hashFile computes the hex SHA-256 of a file by reading it
+func hashFile(fsys fileSystem, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
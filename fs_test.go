@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCopyFileAttrsResume(t *testing.T) {
+	const content = "0123456789abcdefghij"
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name    string
+		partial string // contents of dst.part, "" for none
+		info    string // contents of dst.part-info, "" for none
+		stale   bool   // info records a different source mtime
+		wantN   int64
+	}{
+		{name: "no partial", wantN: int64(len(content))},
+		{name: "resume", partial: content[:8], info: "source", wantN: int64(len(content) - 8)},
+		{name: "partial without info", partial: content[:8], wantN: int64(len(content))},
+		{name: "source changed", partial: content[:8], info: "source", stale: true, wantN: int64(len(content))},
+		{name: "partial too large", partial: content + "extra", info: "source", wantN: int64(len(content))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			srcPath, dstPath := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+			if err := os.WriteFile(srcPath, []byte(content), 0640); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(srcPath, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(srcPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.partial != "" {
+				if err := os.WriteFile(dstPath+partSuffix, []byte(tt.partial), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.info != "" {
+				recorded := partInfo(info)
+				if tt.stale {
+					recorded = fmt.Sprintf("%d %d\n", info.Size(), mtime.Add(-time.Minute).UnixNano())
+				}
+				if err := os.WriteFile(dstPath+partInfoSuffix, []byte(recorded), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := copyFileAttrs(context.Background(), localFS{}, srcPath, localFS{}, dstPath, info, transferOptions{Verify: true}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.wantN {
+				t.Errorf("copied %d bytes, want %d", n, tt.wantN)
+			}
+			got, err := os.ReadFile(dstPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != content {
+				t.Errorf("copy = %q, want %q", got, content)
+			}
+			for _, leftover := range []string{dstPath + partSuffix, dstPath + partInfoSuffix} {
+				if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+					t.Errorf("%s left behind", filepath.Base(leftover))
+				}
+			}
+			copied, err := os.Stat(dstPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if copied.Mode().Perm() != 0640 {
+				t.Errorf("mode = %v, want %v", copied.Mode().Perm(), os.FileMode(0640))
+			}
+			if !copied.ModTime().Equal(mtime) {
+				t.Errorf("mtime = %v, want %v", copied.ModTime(), mtime)
+			}
+		})
+	}
+}
+
+func TestCopyFileAttrsCancelled(t *testing.T) {
+	dir := t.TempDir()
+	srcPath, dstPath := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := os.WriteFile(srcPath, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := copyFileAttrs(ctx, localFS{}, srcPath, localFS{}, dstPath, info, transferOptions{}, nil); err == nil {
+		t.Fatal("cancelled copy succeeded")
+	}
+	if _, err := os.Stat(dstPath); !os.IsNotExist(err) {
+		t.Error("cancelled copy created the destination")
+	}
+	partial, err := os.Stat(dstPath + partSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partial.Mode().Perm() != 0600 {
+		t.Errorf("partial mode = %v, want %v", partial.Mode().Perm(), os.FileMode(0600))
+	}
+	if got := readPartInfo(localFS{}, dstPath+partInfoSuffix); got != partInfo(info) {
+		t.Errorf("part info = %q, want %q", got, partInfo(info))
+	}
+}
+
+func TestRemoveTree(t *testing.T) {
+	tests := []struct {
+		name        string
+		cancel      bool
+		wantRemoved int64
+		wantGone    bool
+	}{
+		{name: "tree", wantRemoved: 6, wantGone: true},
+		{name: "cancelled", cancel: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outside := filepath.Join(dir, "outside")
+			root := filepath.Join(dir, "root")
+			for _, p := range []string{outside, filepath.Join(root, "a", "b")} {
+				if err := os.MkdirAll(p, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, p := range []string{filepath.Join(outside, "keep"), filepath.Join(root, "f"), filepath.Join(root, "a", "b", "g")} {
+				if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink(outside, filepath.Join(root, "a", "link")); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			var removed atomic.Int64
+			err := removeTree(ctx, localFS{}, root, &removed)
+			if tt.cancel && !errors.Is(err, context.Canceled) {
+				t.Errorf("removeTree() error = %v, want %v", err, context.Canceled)
+			} else if !tt.cancel && err != nil {
+				t.Fatal(err)
+			}
+			if got := removed.Load(); got != tt.wantRemoved {
+				t.Errorf("removed %d entries, want %d", got, tt.wantRemoved)
+			}
+			if _, err := os.Lstat(root); os.IsNotExist(err) != tt.wantGone {
+				t.Errorf("root gone = %v, want %v", os.IsNotExist(err), tt.wantGone)
+			}
+			if _, err := os.Stat(filepath.Join(outside, "keep")); err != nil {
+				t.Errorf("followed the symlink: %v", err)
+			}
+		})
+	}
+}
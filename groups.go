@@ -0,0 +1,585 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Group is a folder of servers in the list. Groups nest through ParentID.
+// Servers leave Username, Port or PemKey empty to inherit them from the
+// nearest enclosing group that sets them.
+type Group struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	ParentID      int    `json:"parent_id,omitempty"` // 0 for a top-level group
+	Username      string `json:"username,omitempty"`
+	Port          int    `json:"port,omitempty"`
+	PemKey        string `json:"pem_key,omitempty"`
+	KeyPassphrase string `json:"key_passphrase,omitempty"` // of an encrypted PemKey
+	Collapsed     bool   `json:"collapsed,omitempty"`
+}
+
+// group returns the group with the given ID, or nil
+func (c *Config) group(id int) *Group {
+	for i := range c.Groups {
+		if c.Groups[i].ID == id {
+			return &c.Groups[i]
+		}
+	}
+	return nil
+}
+
+// parentGroup returns the group a server or group with groupID belongs to,
+// treating a group that no longer exists as the top level.
+func (c *Config) parentGroup(groupID int) int {
+	if c.group(groupID) == nil {
+		return 0
+	}
+	return groupID
+}
+
+// groupChain returns the group with the given ID and its ancestors, nearest
+// first. A parent loop in a hand-edited config ends the chain.
+func (c *Config) groupChain(id int) []*Group {
+	var chain []*Group
+	seen := map[int]bool{}
+	for g := c.group(id); g != nil && !seen[g.ID]; g = c.group(g.ParentID) {
+		seen[g.ID] = true
+		chain = append(chain, g)
+	}
+	return chain
+}
+
+// groupPath returns the names from the top-level group down to id, joined
+// with slashes, or "" for the top level.
+func (c *Config) groupPath(id int) string {
+	chain := c.groupChain(id)
+	names := make([]string, len(chain))
+	for i, g := range chain {
+		names[len(chain)-1-i] = g.Name
+	}
+	return strings.Join(names, "/")
+}
+
+// ensureGroupPath returns the group at a slash-separated path, creating the
+// groups that are missing.
+func (c *Config) ensureGroupPath(path string) int {
+	parent := 0
+	for _, name := range strings.Split(path, "/") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id := 0
+		for _, g := range c.Groups {
+			if g.ParentID == parent && g.Name == name {
+				id = g.ID
+				break
+			}
+		}
+		if id == 0 {
+			id = c.NextID
+			c.NextID++
+			c.Groups = append(c.Groups, Group{ID: id, Name: name, ParentID: parent})
+		}
+		parent = id
+	}
+	return parent
+}
+
+// inGroup reports whether group id is ancestor or equal to groupID
+func (c *Config) inGroup(groupID, id int) bool {
+	for _, g := range c.groupChain(groupID) {
+		if g.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// groupServers returns the servers in a group and its subgroups
+func (c *Config) groupServers(id int) []Server {
+	var servers []Server
+	for _, s := range c.Servers {
+		if c.inGroup(s.GroupID, id) {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// hasCredentials reports whether the server chose how to authenticate
+// itself, so it inherits no key.
+func (s Server) hasCredentials() bool {
+	return s.Password != "" || s.PemKey != "" || s.AgentKey != ""
+}
+
+// resolveServer fills in what a server leaves empty from its identity and
+// its groups, then the defaults: port 22 and SFTP on the SSH port. Servers are resolved
+// before connecting; the config keeps them as entered.
+func (c *Config) resolveServer(s Server) Server {
+	if id := c.identity(s.IdentityID); id != nil && !s.hasCredentials() {
+		s.PemKey, s.KeyPassphrase = id.PrivateKey, id.Passphrase
+	}
+	for _, g := range c.groupChain(s.GroupID) {
+		if s.Username == "" {
+			s.Username = g.Username
+		}
+		if s.Port == 0 {
+			s.Port = g.Port
+		}
+		if !s.hasCredentials() {
+			s.PemKey, s.KeyPassphrase = g.PemKey, g.KeyPassphrase
+		}
+	}
+	if s.Port == 0 {
+		s.Port = 22
+	}
+	if s.SFTPPort == 0 {
+		s.SFTPPort = s.Port
+	}
+	return s
+}
+
+// --- Server list rows ---
+
+// groupItem is a group row of the server list
+type groupItem struct {
+	Group
+	depth int
+	count int // servers in the group and its subgroups
+}
+
+func (g groupItem) Title() string {
+	arrow := "▾"
+	if g.Collapsed {
+		arrow = "▸"
+	}
+	return strings.Repeat("  ", g.depth) + arrow + " " + g.Name
+}
+
+func (g groupItem) Description() string {
+	desc := countServers(g.count)
+	if g.Username != "" {
+		desc += " • user " + g.Username
+	}
+	if g.Port != 0 {
+		desc += fmt.Sprintf(" • port %d", g.Port)
+	}
+	if g.PemKey != "" {
+		desc += " • key"
+	}
+	return strings.Repeat("  ", g.depth) + "  " + desc
+}
+
+// The filter matches the title as shown, so matches are highlighted in
+// the right place.
+func (g groupItem) FilterValue() string { return g.Title() }
+
+// serverItem is a server row of the server list, indented under its group
+type serverItem struct {
+	Server
+	depth int
+	desc  string // connection of the server with inherited values filled in
+}
+
+func (s serverItem) Title() string       { return strings.Repeat("  ", s.depth) + s.Name }
+func (s serverItem) Description() string { return strings.Repeat("  ", s.depth) + s.desc }
+func (s serverItem) FilterValue() string {
+	return strings.TrimSpace(s.Title() + " " + strings.Join(s.Tags, " "))
+}
+
+// listServer returns the server of a list row
+func listServer(item list.Item) (Server, bool) {
+	s, ok := item.(serverItem)
+	return s.Server, ok
+}
+
+// listItems lays the groups and servers out as a tree, groups before the
+// servers on each level. Members of collapsed groups are left out. With
+// tags, only servers carrying all of them are listed, and groups without
+// any such server are left out too.
+func (c *Config) listItems(tags []string) []list.Item {
+	var items []list.Item
+	var walk func(parent, depth int)
+	walk = func(parent, depth int) {
+		for _, g := range c.Groups {
+			if c.parentGroup(g.ParentID) != parent || g.ID == parent || depth > len(c.Groups) {
+				continue
+			}
+			count := 0
+			for _, s := range c.groupServers(g.ID) {
+				if s.hasTags(tags) {
+					count++
+				}
+			}
+			if count == 0 && len(tags) > 0 {
+				continue
+			}
+			items = append(items, groupItem{Group: g, depth: depth, count: count})
+			if !g.Collapsed {
+				walk(g.ID, depth+1)
+			}
+		}
+		for _, s := range c.Servers {
+			if c.parentGroup(s.GroupID) == parent && s.hasTags(tags) {
+				items = append(items, serverItem{Server: s, depth: depth, desc: c.resolveServer(s).Description()})
+			}
+		}
+	}
+	walk(0, 0)
+	return items
+}
+
+// contextGroup is the group new servers and groups go into: the selected
+// group, or the group of the selected server.
+func (m model) contextGroup() int {
+	switch item := m.list.SelectedItem().(type) {
+	case groupItem:
+		return item.ID
+	case serverItem:
+		return m.config.parentGroup(item.GroupID)
+	}
+	return 0
+}
+
+// toggleGroup collapses or expands a group and keeps the cursor on it
+func (m *model) toggleGroup(id int) {
+	g := m.config.group(id)
+	if g == nil {
+		return
+	}
+	g.Collapsed = !g.Collapsed
+	m.saveConfig()
+	m.refreshList()
+}
+
+// countServers says how many servers there are, e.g. "1 server"
+func countServers(n int) string {
+	if n == 1 {
+		return "1 server"
+	}
+	return fmt.Sprintf("%d servers", n)
+}
+
+// deleteGroup removes a group. Its servers and subgroups move up to the
+// group's parent, and servers keep what they inherited from it.
+func (m *model) deleteGroup(id int) {
+	g := m.config.group(id)
+	if g == nil {
+		return
+	}
+	parent := g.ParentID
+	resolved := map[int]Server{}
+	for i, s := range m.config.Servers {
+		if m.config.inGroup(s.GroupID, id) {
+			resolved[s.ID] = m.config.resolveServer(s)
+		}
+		if s.GroupID == id {
+			m.config.Servers[i].GroupID = parent
+		}
+	}
+	var groups []Group
+	for _, other := range m.config.Groups {
+		if other.ID == id {
+			continue
+		}
+		if other.ParentID == id {
+			other.ParentID = parent
+		}
+		groups = append(groups, other)
+	}
+	m.config.Groups = groups
+
+	for i, s := range m.config.Servers {
+		before, ok := resolved[s.ID]
+		if !ok {
+			continue
+		}
+		after := m.config.resolveServer(s)
+		if after.Username != before.Username {
+			m.config.Servers[i].Username = before.Username
+		}
+		if after.Port != before.Port {
+			m.config.Servers[i].Port = before.Port
+		}
+		if after.PemKey != before.PemKey && !s.hasCredentials() {
+			m.config.Servers[i].PemKey = before.PemKey
+			m.config.Servers[i].KeyPassphrase = before.KeyPassphrase
+		}
+	}
+	m.saveConfig()
+	m.refreshList()
+}
+
+// setInheritedPlaceholders shows what servers in groupID inherit in the
+// placeholders of the server form.
+func (m *model) setInheritedPlaceholders(groupID int) {
+	inherited := m.config.resolveServer(Server{GroupID: groupID})
+	if inherited.Username != "" {
+		m.inputs[3].Placeholder = "inherited: " + inherited.Username
+	}
+	if inherited.Port != 22 {
+		m.inputs[2].Placeholder = fmt.Sprintf("inherited: %d", inherited.Port)
+	}
+	if inherited.PemKey != "" {
+		m.inputs[5].Placeholder = "inherited from group (press 'p' to edit)"
+	}
+}
+
+// --- Group form ---
+
+// initGroupInputs sets up the group form, filled from g when editing
+func (m *model) initGroupInputs(g Group) {
+	m.inputs = make([]textinput.Model, 4)
+	labels := []struct{ prompt, placeholder, value string }{
+		{"Name: ", "prod, staging, customer-x", g.Name},
+		{"User: ", "default user (optional)", g.Username},
+		{"Port: ", "default port (optional)", ""},
+		{"PEM:  ", "default PEM key (optional)", g.PemKey},
+	}
+	if g.Port != 0 {
+		labels[2].value = strconv.Itoa(g.Port)
+	}
+	for i, l := range labels {
+		m.inputs[i] = textinput.New()
+		m.inputs[i].Prompt = l.prompt
+		m.inputs[i].Placeholder = l.placeholder
+		m.inputs[i].Width = 40
+		m.inputs[i].CharLimit = 100
+		m.inputs[i].SetValue(l.value)
+	}
+	m.inputs[3].CharLimit = 10000
+	m.inputs[0].Focus()
+	m.focusIndex = 0
+}
+
+// openGroupForm edits group id, or adds a group inside parent if id is 0
+func (m *model) openGroupForm(id, parent int) {
+	g := Group{ParentID: parent}
+	if existing := m.config.group(id); existing != nil {
+		g = *existing
+	}
+	m.editingGroupID = id
+	m.groupParentID = g.ParentID
+	m.initGroupInputs(g)
+	m.state = groupFormView
+	m.message = ""
+}
+
+func (m *model) saveGroupForm() bool {
+	name := strings.TrimSpace(m.inputs[0].Value())
+	username := strings.TrimSpace(m.inputs[1].Value())
+	portStr := strings.TrimSpace(m.inputs[2].Value())
+	pemKey := m.inputs[3].Value()
+
+	if name == "" || strings.Contains(name, "/") {
+		m.message = "Error: Name is required and cannot contain /"
+		return false
+	}
+	port := 0
+	if portStr != "" {
+		var err error
+		port, err = strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			m.message = "Error: Invalid port number"
+			return false
+		}
+	}
+	if pemKey != "" {
+		normalized := normalizePemKey(pemKey)
+		if !strings.Contains(normalized, "BEGIN") || !strings.Contains(normalized, "PRIVATE KEY") {
+			m.message = "Error: Invalid PEM key format"
+			return false
+		}
+	}
+
+	g := m.config.group(m.editingGroupID)
+	if g == nil {
+		m.config.Groups = append(m.config.Groups, Group{ID: m.config.NextID, ParentID: m.groupParentID})
+		m.config.NextID++
+		g = &m.config.Groups[len(m.config.Groups)-1]
+		m.message = fmt.Sprintf("Added group: %s", name)
+	} else {
+		m.message = fmt.Sprintf("Updated group: %s", name)
+	}
+	g.Name, g.Username, g.Port, g.PemKey = name, username, port, pemKey
+
+	if err := m.saveConfig(); err != nil {
+		m.message = fmt.Sprintf("Error saving config: %v", err)
+		return false
+	}
+	m.refreshList()
+	return true
+}
+
+func (m model) updateGroupFormView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+
+	case "esc":
+		m.state = listView
+		m.message = ""
+		return m, nil
+
+	case "tab", "shift+tab", "up", "down":
+		if s := msg.String(); s == "up" || s == "shift+tab" {
+			m.focusIndex--
+		} else {
+			m.focusIndex++
+		}
+		if m.focusIndex > len(m.inputs) {
+			m.focusIndex = 0
+		} else if m.focusIndex < 0 {
+			m.focusIndex = len(m.inputs)
+		}
+		for i := range m.inputs {
+			if i == m.focusIndex {
+				m.inputs[i].Focus()
+			} else {
+				m.inputs[i].Blur()
+			}
+		}
+		return m, nil
+
+	case "enter":
+		if m.focusIndex == len(m.inputs) {
+			if m.saveGroupForm() {
+				m.state = listView
+			}
+			return m, nil
+		}
+	}
+
+	cmd := m.updateInputs(msg)
+	return m, cmd
+}
+
+// --- Moving servers and groups ---
+
+// groupChoice is a destination in the group picker
+type groupChoice struct {
+	ID    int
+	Label string
+}
+
+// startMove picks a new group for the marked servers, or else for the
+// selected server or group.
+func (m *model) startMove() {
+	m.moveServerIDs, m.moveGroupID = nil, 0
+	for _, s := range m.config.Servers {
+		if m.serverMarks[s.ID] {
+			m.moveServerIDs = append(m.moveServerIDs, s.ID)
+		}
+	}
+	if len(m.moveServerIDs) == 0 {
+		switch item := m.list.SelectedItem().(type) {
+		case serverItem:
+			m.moveServerIDs = []int{item.ID}
+		case groupItem:
+			m.moveGroupID = item.ID
+		default:
+			return
+		}
+	}
+
+	// A group cannot move into itself or below itself
+	m.groupChoices = []groupChoice{{ID: 0, Label: "(top level)"}}
+	var walk func(parent, depth int)
+	walk = func(parent, depth int) {
+		for _, g := range m.config.Groups {
+			if m.config.parentGroup(g.ParentID) != parent || g.ID == parent || depth > len(m.config.Groups) {
+				continue
+			}
+			if m.moveGroupID != 0 && g.ID == m.moveGroupID {
+				continue
+			}
+			m.groupChoices = append(m.groupChoices, groupChoice{ID: g.ID, Label: strings.Repeat("  ", depth+1) + g.Name})
+			walk(g.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+	m.groupCursor = 0
+	m.state = groupPickView
+	m.message = ""
+}
+
+// applyMove moves what startMove picked into group id
+func (m *model) applyMove(id int) {
+	if m.moveGroupID != 0 {
+		g := m.config.group(m.moveGroupID)
+		g.ParentID = id
+		m.message = fmt.Sprintf("Moved group %s", g.Name)
+	} else {
+		moving := map[int]bool{}
+		for _, sid := range m.moveServerIDs {
+			moving[sid] = true
+		}
+		for i := range m.config.Servers {
+			if moving[m.config.Servers[i].ID] {
+				m.config.Servers[i].GroupID = id
+			}
+		}
+		m.serverMarks.clear()
+		m.message = "Moved " + countServers(len(m.moveServerIDs))
+	}
+	if path := m.config.groupPath(id); path != "" {
+		m.message += " to " + path
+	} else {
+		m.message += " to the top level"
+	}
+	if err := m.saveConfig(); err != nil {
+		m.message = fmt.Sprintf("Error saving config: %v", err)
+	}
+	m.refreshList()
+}
+
+func (s serverMarks) clear() {
+	for id := range s {
+		delete(s, id)
+	}
+}
+
+func (m model) updateGroupPickView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "q":
+		m.state = listView
+		return m, nil
+	case "up", "k":
+		if m.groupCursor > 0 {
+			m.groupCursor--
+		}
+	case "down", "j":
+		if m.groupCursor < len(m.groupChoices)-1 {
+			m.groupCursor++
+		}
+	case "enter":
+		m.applyMove(m.groupChoices[m.groupCursor].ID)
+		m.state = listView
+	}
+	return m, nil
+}
+
+func (m model) viewGroupPick() string {
+	what := countServers(len(m.moveServerIDs))
+	if m.moveGroupID != 0 {
+		what = "group " + m.config.group(m.moveGroupID).Name
+	}
+	s := titleStyle.Render("Move "+what+" to") + "\n\n"
+	for i, choice := range m.groupChoices {
+		cursor := " "
+		if m.groupCursor == i {
+			cursor = ">"
+		}
+		s += fmt.Sprintf("%s %s\n", cursor, choice.Label)
+	}
+	s += "\n" + helpStyle.Render("Use ↑/↓ to navigate, [enter] to move, [esc] to cancel")
+	return s
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// groupsTestConfig has a group tree prod > web, db and an identity
+func groupsTestConfig() *Config {
+	return &Config{
+		Groups: []Group{
+			{ID: 1, Name: "prod", Username: "deploy", Port: 2222, PemKey: "prodkey", KeyPassphrase: "prodpass"},
+			{ID: 2, Name: "web", ParentID: 1, Username: "www"},
+			{ID: 3, Name: "db", ParentID: 1, PemKey: "dbkey"},
+		},
+		Identities: []Identity{{ID: 7, Name: "ops", PrivateKey: "idkey", Passphrase: "idpass"}},
+	}
+}
+
+func TestResolveServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Server
+		want   Server
+	}{
+		{
+			name:   "no group",
+			server: Server{Host: "a"},
+			want:   Server{Host: "a", Port: 22, SFTPPort: 22},
+		},
+		{
+			name:   "group",
+			server: Server{GroupID: 1},
+			want:   Server{GroupID: 1, Username: "deploy", Port: 2222, SFTPPort: 2222, PemKey: "prodkey", KeyPassphrase: "prodpass"},
+		},
+		{
+			name:   "nearest group first",
+			server: Server{GroupID: 2},
+			want:   Server{GroupID: 2, Username: "www", Port: 2222, SFTPPort: 2222, PemKey: "prodkey", KeyPassphrase: "prodpass"},
+		},
+		{
+			name:   "nearest group key without its parent's passphrase",
+			server: Server{GroupID: 3},
+			want:   Server{GroupID: 3, Username: "deploy", Port: 2222, SFTPPort: 2222, PemKey: "dbkey"},
+		},
+		{
+			name:   "own values",
+			server: Server{GroupID: 2, Username: "me", Port: 2200, SFTPPort: 22, PemKey: "mykey", KeyPassphrase: "mypass"},
+			want:   Server{GroupID: 2, Username: "me", Port: 2200, SFTPPort: 22, PemKey: "mykey", KeyPassphrase: "mypass"},
+		},
+		{
+			name:   "password",
+			server: Server{GroupID: 1, Password: "pw"},
+			want:   Server{GroupID: 1, Username: "deploy", Port: 2222, SFTPPort: 2222, Password: "pw"},
+		},
+		{
+			name:   "agent key",
+			server: Server{GroupID: 1, AgentKey: "SHA256:abc"},
+			want:   Server{GroupID: 1, Username: "deploy", Port: 2222, SFTPPort: 2222, AgentKey: "SHA256:abc"},
+		},
+		{
+			name:   "identity",
+			server: Server{GroupID: 1, IdentityID: 7},
+			want:   Server{GroupID: 1, IdentityID: 7, Username: "deploy", Port: 2222, SFTPPort: 2222, PemKey: "idkey", KeyPassphrase: "idpass"},
+		},
+		{
+			name:   "identity and password",
+			server: Server{IdentityID: 7, Password: "pw"},
+			want:   Server{IdentityID: 7, Password: "pw", Port: 22, SFTPPort: 22},
+		},
+		{
+			name:   "missing identity",
+			server: Server{GroupID: 1, IdentityID: 8},
+			want:   Server{GroupID: 1, IdentityID: 8, Username: "deploy", Port: 2222, SFTPPort: 2222, PemKey: "prodkey", KeyPassphrase: "prodpass"},
+		},
+	}
+	c := groupsTestConfig()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.resolveServer(tt.server); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveServer():\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	c := groupsTestConfig()
+	c.Servers = []Server{
+		{ID: 1, Name: "web1", GroupID: 2},
+		{ID: 2, Name: "password", GroupID: 1, Password: "pw"},
+		{ID: 3, Name: "agent", GroupID: 1, AgentKey: "SHA256:abc"},
+		{ID: 4, Name: "db1", GroupID: 3},
+		{ID: 5, Name: "identity", GroupID: 1, IdentityID: 7},
+		{ID: 6, Name: "own", GroupID: 1, Username: "me", Port: 2200, PemKey: "mykey"},
+		{ID: 7, Name: "elsewhere", Username: "u"},
+	}
+	m := model{
+		config:     c,
+		configPath: filepath.Join(t.TempDir(), "config.json"),
+		list:       list.New(nil, list.NewDefaultDelegate(), 0, 0),
+	}
+	resolved := map[int]Server{}
+	for _, s := range c.Servers {
+		resolved[s.ID] = c.resolveServer(s)
+	}
+
+	m.deleteGroup(1)
+
+	want := []Server{
+		{ID: 1, Name: "web1", GroupID: 2, Port: 2222, PemKey: "prodkey", KeyPassphrase: "prodpass"},
+		{ID: 2, Name: "password", Password: "pw", Username: "deploy", Port: 2222},
+		{ID: 3, Name: "agent", AgentKey: "SHA256:abc", Username: "deploy", Port: 2222},
+		{ID: 4, Name: "db1", GroupID: 3, Username: "deploy", Port: 2222},
+		{ID: 5, Name: "identity", IdentityID: 7, Username: "deploy", Port: 2222},
+		{ID: 6, Name: "own", Username: "me", Port: 2200, PemKey: "mykey"},
+		{ID: 7, Name: "elsewhere", Username: "u"},
+	}
+	if !reflect.DeepEqual(c.Servers, want) {
+		t.Errorf("servers:\n got %+v\nwant %+v", c.Servers, want)
+	}
+	for _, s := range c.Servers {
+		before := resolved[s.ID]
+		before.GroupID = s.GroupID
+		if got := c.resolveServer(s); !reflect.DeepEqual(got, before) {
+			t.Errorf("server %s resolves to %+v, was %+v", s.Name, got, before)
+		}
+	}
+
+	wantGroups := []Group{
+		{ID: 2, Name: "web", Username: "www"},
+		{ID: 3, Name: "db", PemKey: "dbkey"},
+	}
+	if !reflect.DeepEqual(c.Groups, wantGroups) {
+		t.Errorf("groups = %+v, want %+v", c.Groups, wantGroups)
+	}
+	if _, err := os.Stat(m.configPath); err != nil {
+		t.Errorf("config not saved: %v", err)
+	}
+}
+
+func TestExportKeepsInheritance(t *testing.T) {
+	c := groupsTestConfig()
+	c.Servers = []Server{
+		{ID: 1, Name: "web1", Host: "w", GroupID: 2},
+		{ID: 2, Name: "ci", Host: "c", GroupID: 1, IdentityID: 7},
+		{ID: 3, Name: "own", Host: "o", Port: 2200, Password: "pw", Tags: []string{"env:prod"}},
+	}
+	c.NextID = 10
+	path := filepath.Join(t.TempDir(), "export.json")
+	m := model{config: c, configPath: filepath.Join(t.TempDir(), "config.json"), list: list.New(nil, list.NewDefaultDelegate(), 0, 0)}
+	m.exportServersToPath(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"prodkey", "prodpass", "idkey", "idpass"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("export contains inherited secret %q", secret)
+		}
+	}
+
+	// Import into a config that only has the identity
+	imported := &Config{NextID: 1, Identities: []Identity{{ID: 7, Name: "ops"}}}
+	m = model{config: imported, configPath: filepath.Join(t.TempDir(), "config.json"), list: list.New(nil, list.NewDefaultDelegate(), 0, 0)}
+	m.importServersFromPath(path)
+
+	want := map[string]struct {
+		group    string
+		identity int
+		port     int
+		password string
+	}{
+		"web1": {group: "prod/web"},
+		"ci":   {group: "prod", identity: 7},
+		"own":  {port: 2200, password: "pw"},
+	}
+	if len(imported.Servers) != len(want) {
+		t.Fatalf("imported %d servers, want %d: %s", len(imported.Servers), len(want), m.message)
+	}
+	for _, s := range imported.Servers {
+		w := want[s.Name]
+		if got := imported.groupPath(s.GroupID); got != w.group {
+			t.Errorf("%s group = %q, want %q", s.Name, got, w.group)
+		}
+		if s.IdentityID != w.identity || s.Port != w.port || s.Password != w.password || s.PemKey != "" || s.KeyPassphrase != "" {
+			t.Errorf("%s imported as %+v", s.Name, s)
+		}
+	}
+}
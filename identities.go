@@ -0,0 +1,398 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+// identityInput is the index of the identity field in the server form
+const identityInput = 9
+
+// Identity is a private key kept once in the config and used by any number
+// of servers, so replacing the key updates every server at once.
+type Identity struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase,omitempty"` // for an encrypted PrivateKey
+	PublicKey  string `json:"public_key"`           // authorized_keys line
+}
+
+// identity returns the identity with the given ID, or nil
+func (c *Config) identity(id int) *Identity {
+	if id == 0 {
+		return nil
+	}
+	for i := range c.Identities {
+		if c.Identities[i].ID == id {
+			return &c.Identities[i]
+		}
+	}
+	return nil
+}
+
+// identityUsers returns the names of the servers using identity id
+func (c *Config) identityUsers(id int) []string {
+	var names []string
+	for _, s := range c.Servers {
+		if s.IdentityID == id {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// publicKeyLine returns the authorized_keys line of a private key. An
+// encrypted key given without its passphrase is not an error: the
+// passphrase is asked for when connecting. Its public key is then taken
+// from the unencrypted part of the key file, or is "" if the key format
+// has none.
+func publicKeyLine(privateKey, passphrase string) (string, error) {
+	signer, err := parseSigner(privateKey, passphrase)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing):
+		if missing.PublicKey == nil {
+			return "", nil
+		}
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(missing.PublicKey))), nil
+	case err != nil:
+		return "", err
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// readKeyField returns the key entered in a form: PEM text as pasted, or
+// the contents of the key file at that path.
+func readKeyField(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.Contains(value, "BEGIN") {
+		return value, nil
+	}
+	if strings.HasPrefix(value, "~/") {
+		value = filepath.Join(os.Getenv("HOME"), value[2:])
+	}
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return "", fmt.Errorf("reading key file: %v", err)
+	}
+	return string(data), nil
+}
+
+// --- Identities view ---
+
+func (m *model) openIdentities() {
+	m.identityCursor = 0
+	m.state = identitiesView
+	m.message = ""
+}
+
+func (m model) updateIdentitiesView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	ids := m.config.Identities
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+
+	case "esc", "q":
+		m.state = menuView
+		m.message = ""
+
+	case "up", "k":
+		if m.identityCursor > 0 {
+			m.identityCursor--
+		}
+
+	case "down", "j":
+		if m.identityCursor < len(ids)-1 {
+			m.identityCursor++
+		}
+
+	case "a":
+		m.openIdentityForm(0)
+
+	case "e", "enter":
+		if m.identityCursor < len(ids) {
+			m.openIdentityForm(ids[m.identityCursor].ID)
+		}
+
+	case "d":
+		if m.identityCursor >= len(ids) {
+			break
+		}
+		id := ids[m.identityCursor]
+		if users := m.config.identityUsers(id.ID); len(users) > 0 {
+			m.message = fmt.Sprintf("Error: %s is used by %s", id.Name, strings.Join(users, ", "))
+			break
+		}
+		m.config.Identities = append(ids[:m.identityCursor:m.identityCursor], ids[m.identityCursor+1:]...)
+		if m.identityCursor > 0 && m.identityCursor >= len(m.config.Identities) {
+			m.identityCursor--
+		}
+		if err := m.saveConfig(); err != nil {
+			m.message = fmt.Sprintf("Error saving config: %v", err)
+		} else {
+			m.message = fmt.Sprintf("Deleted identity: %s", id.Name)
+		}
+	}
+	return m, nil
+}
+
+func (m model) viewIdentities() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Identities") + "\n\n")
+
+	if len(m.config.Identities) == 0 {
+		b.WriteString(helpStyle.Render("No identities yet. Press [a] to add a private key.") + "\n")
+	}
+	for i, id := range m.config.Identities {
+		cursor := " "
+		if m.identityCursor == i {
+			cursor = ">"
+		}
+		users := len(m.config.identityUsers(id.ID))
+		fmt.Fprintf(&b, "%s %s  %s\n", cursor, id.Name, helpStyle.Render(fmt.Sprintf("used by %s", countServers(users))))
+	}
+
+	if m.identityCursor < len(m.config.Identities) {
+		id := m.config.Identities[m.identityCursor]
+		if id.PublicKey == "" {
+			b.WriteString("\n" + helpStyle.Render("Public key unknown: the private key is encrypted") + "\n")
+		} else {
+			b.WriteString("\n" + helpStyle.Render(id.PublicKey) + "\n")
+		}
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(id.PublicKey)); err == nil {
+			b.WriteString(helpStyle.Render(ssh.FingerprintSHA256(pub)) + "\n")
+		}
+	}
+
+	b.WriteString("\n" + helpStyle.Render("[a]dd • [e]dit • [d]elete • [esc] back"))
+	if m.message != "" {
+		msgStyle := messageStyle
+		if strings.HasPrefix(m.message, "Error") {
+			msgStyle = errorStyle
+		}
+		b.WriteString("\n\n" + msgStyle.Render(m.message))
+	}
+	return b.String()
+}
+
+// --- Identity form ---
+
+// openIdentityForm edits identity id, or adds one if id is 0
+func (m *model) openIdentityForm(id int) {
+	var ident Identity
+	if existing := m.config.identity(id); existing != nil {
+		ident = *existing
+	}
+	m.editingIdentityID = id
+
+	m.inputs = make([]textinput.Model, 3)
+	labels := []struct{ prompt, placeholder, value string }{
+		{"Name: ", "deploy key, laptop, ci", ident.Name},
+		{"Key:  ", "paste PEM key or enter a path like ~/.ssh/id_ed25519", ident.PrivateKey},
+		{"Pass: ", "passphrase of the key (optional)", ident.Passphrase},
+	}
+	for i, l := range labels {
+		m.inputs[i] = textinput.New()
+		m.inputs[i].Prompt = l.prompt
+		m.inputs[i].Placeholder = l.placeholder
+		m.inputs[i].Width = 40
+		m.inputs[i].CharLimit = 100
+		m.inputs[i].SetValue(l.value)
+	}
+	m.inputs[1].CharLimit = 10000
+	m.inputs[2].EchoMode = textinput.EchoPassword
+	m.inputs[2].EchoCharacter = '•'
+	m.inputs[0].Focus()
+	m.focusIndex = 0
+	m.state = identityFormView
+	m.message = ""
+}
+
+func (m *model) saveIdentityForm() bool {
+	name := strings.TrimSpace(m.inputs[0].Value())
+	passphrase := m.inputs[2].Value()
+	if name == "" {
+		m.message = "Error: Name is required"
+		return false
+	}
+	key, err := readKeyField(m.inputs[1].Value())
+	if err != nil {
+		m.message = fmt.Sprintf("Error: %v", err)
+		return false
+	}
+	if key == "" {
+		m.message = "Error: Private key is required"
+		return false
+	}
+	key = normalizePemKey(key)
+	publicKey, err := publicKeyLine(key, passphrase)
+	if err != nil {
+		m.message = fmt.Sprintf("Error: %v", err)
+		return false
+	}
+
+	ident := m.config.identity(m.editingIdentityID)
+	if ident == nil {
+		m.config.Identities = append(m.config.Identities, Identity{ID: m.config.NextID})
+		m.config.NextID++
+		ident = &m.config.Identities[len(m.config.Identities)-1]
+		m.identityCursor = len(m.config.Identities) - 1
+		m.message = fmt.Sprintf("Added identity: %s", name)
+	} else {
+		m.message = fmt.Sprintf("Updated identity: %s, used by %s", name, countServers(len(m.config.identityUsers(ident.ID))))
+	}
+	ident.Name, ident.PrivateKey, ident.Passphrase, ident.PublicKey = name, key, passphrase, publicKey
+
+	if err := m.saveConfig(); err != nil {
+		m.message = fmt.Sprintf("Error saving config: %v", err)
+		return false
+	}
+	return true
+}
+
+func (m model) updateIdentityFormView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+
+	case "esc":
+		m.state = identitiesView
+		m.message = ""
+		return m, nil
+
+	case "tab", "shift+tab", "up", "down":
+		if s := msg.String(); s == "up" || s == "shift+tab" {
+			m.focusIndex--
+		} else {
+			m.focusIndex++
+		}
+		if m.focusIndex > len(m.inputs) {
+			m.focusIndex = 0
+		} else if m.focusIndex < 0 {
+			m.focusIndex = len(m.inputs)
+		}
+		for i := range m.inputs {
+			if i == m.focusIndex {
+				m.inputs[i].Focus()
+			} else {
+				m.inputs[i].Blur()
+			}
+		}
+		return m, nil
+
+	case "enter":
+		if m.focusIndex == len(m.inputs) {
+			if m.saveIdentityForm() {
+				m.state = identitiesView
+			}
+			return m, nil
+		}
+	}
+
+	cmd := m.updateInputs(msg)
+	return m, cmd
+}
+
+// --- Identity picker of the server form ---
+
+// identityChoice is an entry of the identity picker: an identity, or a
+// key loaded in ssh-agent
+type identityChoice struct {
+	ID       int
+	AgentKey string // SHA256 fingerprint
+	Label    string
+}
+
+// openIdentityPicker picks the identity or agent key of the server in the
+// form.
+func (m *model) openIdentityPicker() {
+	m.identityChoices = []identityChoice{{ID: 0, Label: "(none)"}}
+	m.identityPickCursor = 0
+	for _, id := range m.config.Identities {
+		m.identityChoices = append(m.identityChoices, identityChoice{ID: id.ID, Label: id.Name})
+		if id.ID == m.formIdentityID {
+			m.identityPickCursor = len(m.identityChoices) - 1
+		}
+	}
+
+	keys, err := agentKeys()
+	m.identityAgentErr = ""
+	if err != nil {
+		m.identityAgentErr = err.Error()
+	}
+	found := m.formAgentKey == ""
+	for _, k := range keys {
+		fp := ssh.FingerprintSHA256(k)
+		m.identityChoices = append(m.identityChoices, identityChoice{AgentKey: fp, Label: fmt.Sprintf("agent: %s (%s) %s", k.Comment, k.Type(), fp)})
+		if fp == m.formAgentKey {
+			m.identityPickCursor = len(m.identityChoices) - 1
+			found = true
+		}
+	}
+	if !found {
+		m.identityChoices = append(m.identityChoices, identityChoice{AgentKey: m.formAgentKey, Label: "agent: " + m.formAgentKey + " (not loaded)"})
+		m.identityPickCursor = len(m.identityChoices) - 1
+	}
+	m.identityReturn = m.state
+	m.state = identityPickView
+}
+
+// setFormIdentity shows identity id, or else the agent key with the given
+// fingerprint, in the server form.
+func (m *model) setFormIdentity(id int, agentKey string) {
+	m.formIdentityID, m.formAgentKey = id, ""
+	m.inputs[identityInput].SetValue("")
+	if ident := m.config.identity(id); ident != nil {
+		m.inputs[identityInput].SetValue(ident.Name)
+	} else if agentKey != "" {
+		m.formAgentKey = agentKey
+		m.inputs[identityInput].SetValue("agent " + agentKey)
+	}
+}
+
+func (m model) updateIdentityPickView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "q":
+		m.state = m.identityReturn
+	case "up", "k":
+		if m.identityPickCursor > 0 {
+			m.identityPickCursor--
+		}
+	case "down", "j":
+		if m.identityPickCursor < len(m.identityChoices)-1 {
+			m.identityPickCursor++
+		}
+	case "enter":
+		choice := m.identityChoices[m.identityPickCursor]
+		m.setFormIdentity(choice.ID, choice.AgentKey)
+		m.state = m.identityReturn
+	}
+	return m, nil
+}
+
+func (m model) viewIdentityPick() string {
+	s := titleStyle.Render("Select Identity") + "\n\n"
+	for i, choice := range m.identityChoices {
+		cursor := " "
+		if m.identityPickCursor == i {
+			cursor = ">"
+		}
+		s += fmt.Sprintf("%s %s\n", cursor, choice.Label)
+	}
+	if m.identityAgentErr != "" {
+		s += "\n" + helpStyle.Render("Agent keys unavailable: "+m.identityAgentErr) + "\n"
+	}
+	s += "\n" + helpStyle.Render("Use ↑/↓ to navigate, [enter] to select, [esc] to cancel")
+	s += "\n" + helpStyle.Render("Manage identities from the [m]enu")
+	return s
+}
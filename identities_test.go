@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+// testKeys returns an ed25519 key as unencrypted and encrypted OpenSSH PEM
+// text, with its authorized_keys line.
+func testKeys(t *testing.T, passphrase string) (plain, encrypted, public string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encBlock, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	return string(pem.EncodeToMemory(block)), string(pem.EncodeToMemory(encBlock)), public
+}
+
+// legacyEncryptedKey returns an RSA key in encrypted PEM form, which keeps
+// no public key outside the encrypted part.
+func legacyEncryptedKey(t *testing.T, passphrase string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Deprecated, but such keys are still around
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte(passphrase), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+func TestPublicKeyLine(t *testing.T) {
+	plain, encrypted, public := testKeys(t, "secret")
+	legacy := legacyEncryptedKey(t, "secret")
+
+	tests := []struct {
+		name       string
+		key        string
+		passphrase string
+		want       string
+		wantErr    bool
+	}{
+		{name: "plain", key: plain, want: public},
+		{name: "encrypted", key: encrypted, passphrase: "secret", want: public},
+		{name: "encrypted without passphrase", key: encrypted, want: public},
+		{name: "encrypted with wrong passphrase", key: encrypted, passphrase: "wrong", wantErr: true},
+		{name: "legacy encrypted without passphrase", key: legacy, want: ""},
+		{name: "legacy encrypted with wrong passphrase", key: legacy, passphrase: "wrong", wantErr: true},
+		{name: "not a key", key: "-----BEGIN NOTHING-----\nAAAA\n-----END NOTHING-----\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := publicKeyLine(tt.key, tt.passphrase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("publicKeyLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("publicKeyLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveIdentityForm(t *testing.T) {
+	_, encrypted, public := testKeys(t, "secret")
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, []byte(encrypted), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		passphrase     string
+		wantOK         bool
+		wantPassphrase string
+	}{
+		{name: "with passphrase", passphrase: "secret", wantOK: true, wantPassphrase: "secret"},
+		{name: "without passphrase", wantOK: true},
+		{name: "wrong passphrase", passphrase: "wrong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := model{config: &Config{NextID: 1}, configPath: filepath.Join(t.TempDir(), "config.json")}
+			m.openIdentityForm(0)
+			m.inputs[0].SetValue("deploy")
+			m.inputs[1].SetValue(keyPath)
+			m.inputs[2].SetValue(tt.passphrase)
+
+			if ok := m.saveIdentityForm(); ok != tt.wantOK {
+				t.Fatalf("saveIdentityForm() = %v, want %v (%s)", ok, tt.wantOK, m.message)
+			}
+			if !tt.wantOK {
+				if len(m.config.Identities) != 0 {
+					t.Error("identity saved with a wrong passphrase")
+				}
+				return
+			}
+			id := m.config.Identities[0]
+			if id.Name != "deploy" || id.PublicKey != public || id.Passphrase != tt.wantPassphrase {
+				t.Errorf("saved %+v, want name deploy, public key %q and passphrase %q", id, public, tt.wantPassphrase)
+			}
+		})
+	}
+}
+
+func TestDeleteIdentity(t *testing.T) {
+	tests := []struct {
+		name       string
+		servers    []Server
+		wantKept   bool
+		wantPrefix string
+	}{
+		{name: "unused", servers: []Server{{ID: 1, Name: "web", IdentityID: 2}}, wantPrefix: "Deleted identity: ci"},
+		{name: "in use", servers: []Server{{ID: 1, Name: "web", IdentityID: 1}, {ID: 2, Name: "db", IdentityID: 1}}, wantKept: true, wantPrefix: "Error: ci is used by web, db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := model{
+				config: &Config{
+					Servers:    tt.servers,
+					Identities: []Identity{{ID: 1, Name: "ci"}, {ID: 2, Name: "laptop"}},
+				},
+				configPath: filepath.Join(t.TempDir(), "config.json"),
+				state:      identitiesView,
+			}
+			next, _ := m.updateIdentitiesView(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+			m = next.(model)
+			if !strings.HasPrefix(m.message, tt.wantPrefix) {
+				t.Errorf("message = %q, want %q", m.message, tt.wantPrefix)
+			}
+			if kept := m.config.identity(1) != nil; kept != tt.wantKept {
+				t.Errorf("identity kept = %v, want %v", kept, tt.wantKept)
+			}
+			if m.config.identity(2) == nil {
+				t.Error("other identity deleted")
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+var errAuthCancelled = errors.New("authentication cancelled")
+
+// authChallenge is a round of keyboard-interactive questions from a server,
+// such as a one-time code after the key was accepted.
+type authChallenge struct {
+	Server      string
+	Instruction string
+	Questions   []string
+	Echos       []bool
+	answers     chan []string // closed to cancel
+}
+
+type authChallengeMsg struct {
+	prompter  *authPrompter
+	challenge *authChallenge
+}
+
+// authPrompter passes the challenges of one connection attempt to the UI
+// while the attempt runs in the background.
+type authPrompter struct {
+	challenges chan *authChallenge
+	done       chan struct{} // closed when the attempt ends
+}
+
+func newAuthPrompter() *authPrompter {
+	return &authPrompter{challenges: make(chan *authChallenge), done: make(chan struct{})}
+}
+
+// challenge answers keyboard-interactive auth for server by asking the
+// user. A lone password question is answered with the stored password,
+// once, for servers that take passwords this way.
+func (p *authPrompter) challenge(server *Server) ssh.KeyboardInteractiveChallenge {
+	passwordUsed := false
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) == 0 {
+			return []string{}, nil
+		}
+		if len(questions) == 1 && !echos[0] && server.Password != "" && !passwordUsed &&
+			strings.Contains(strings.ToLower(questions[0]), "password") {
+			passwordUsed = true
+			return []string{server.Password}, nil
+		}
+
+		c := &authChallenge{
+			Server:      server.Name,
+			Instruction: strings.TrimSpace(name + "\n" + instruction),
+			Questions:   questions,
+			Echos:       echos,
+			answers:     make(chan []string, 1),
+		}
+		select {
+		case p.challenges <- c:
+		case <-p.done:
+			return nil, errAuthCancelled
+		}
+		answers, ok := <-c.answers
+		if !ok {
+			return nil, errAuthCancelled
+		}
+		return answers, nil
+	}
+}
+
+func waitForChallenge(p *authPrompter) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case c := <-p.challenges:
+			return authChallengeMsg{prompter: p, challenge: c}
+		case <-p.done:
+			return nil
+		}
+	}
+}
+
+// --- Background connections ---
+
+// connectedMsg is the outcome of a connection started by startConnect
+type connectedMsg struct {
+	seq      int
+	action   string // "ssh", "sftp" or "sftp-pair"
+	servers  []Server
+	client   *ssh.Client    // for "ssh"
+	managers []*SFTPManager // one per server otherwise
+	failed   Server         // the server that could not be reached
+	err      error
+}
+
+// close releases the connections of a result nobody waits for any more
+func (msg connectedMsg) close() {
+	if msg.client != nil {
+		msg.client.Close()
+	}
+	for _, sm := range msg.managers {
+		sm.Close()
+	}
+}
+
+// startConnect connects to the servers in the background, so the UI can
+// ask the questions of keyboard-interactive auth meanwhile.
+func (m *model) startConnect(action string, servers ...Server) tea.Cmd {
+	m.connectSeq++
+	seq, hostKeys := m.connectSeq, m.knownHosts
+	p := newAuthPrompter()
+	m.prompter = p
+
+	names := make([]string, len(servers))
+	for i, s := range servers {
+		names[i] = s.Name
+	}
+	m.connecting = true
+	m.message = fmt.Sprintf("Connecting to %s... ([esc] to abandon)", strings.Join(names, " and "))
+
+	connect := func() tea.Msg {
+		defer close(p.done)
+		msg := connectedMsg{seq: seq, action: action, servers: servers}
+		for i := range servers {
+			s := &servers[i]
+			if action == "ssh" {
+				msg.client, msg.err = dialSSH(context.Background(), s, s.Port, hostKeys, p.challenge(s))
+			} else {
+				var sm *SFTPManager
+				if sm, msg.err = ConnectSFTP(s, hostKeys, p.challenge(s)); msg.err == nil {
+					msg.managers = append(msg.managers, sm)
+				}
+			}
+			if msg.err != nil {
+				msg.failed = *s
+				msg.close()
+				msg.client, msg.managers = nil, nil
+				return msg
+			}
+		}
+		return msg
+	}
+	return tea.Batch(connect, waitForChallenge(p))
+}
+
+// abandonConnect stops waiting for the running connection attempt
+func (m *model) abandonConnect() {
+	m.connectSeq++
+	m.connecting = false
+	m.prompter = nil
+	m.message = "Connection abandoned"
+}
+
+// connected opens the session a finished connection attempt was for
+func (m model) connected(msg connectedMsg) (tea.Model, tea.Cmd) {
+	if msg.seq != m.connectSeq {
+		msg.close()
+		return m, nil
+	}
+	m.connecting = false
+	m.prompter = nil
+	m.message = ""
+
+	if errors.Is(msg.err, errAuthCancelled) {
+		m.message = fmt.Sprintf("Connection to %s cancelled", msg.failed.Name)
+		return m, nil
+	}
+	if msg.err != nil {
+		if !m.handleConnectError(msg.err, msg.action, msg.failed) {
+			switch msg.action {
+			case "ssh":
+				m.message = fmt.Sprintf("Error connecting: %v", msg.err)
+			case "sftp":
+				m.message = fmt.Sprintf("Error connecting to SFTP: %v", msg.err)
+			default:
+				m.message = fmt.Sprintf("Error connecting to %s: %v", msg.failed.Name, msg.err)
+			}
+		}
+		return m, nil
+	}
+
+	switch msg.action {
+	case "ssh":
+		server := msg.servers[0]
+		onExit := func(err error) tea.Msg {
+			return sshSessionEndedMsg{server: server.Name, err: err}
+		}
+		return m, tea.Exec(newSSHTerminal(msg.client, server.ForwardAgent), onExit)
+	case "sftp":
+		m.localPath = os.Getenv("HOME")
+		return m.enterSFTPView(nil, nil, msg.managers[0], msg.servers[0])
+	}
+	m.localPath = "/"
+	left := msg.servers[0]
+	return m.enterSFTPView(msg.managers[0], &left, msg.managers[1], msg.servers[1])
+}
+
+// --- Keyboard-interactive prompt ---
+
+// showChallenge asks the questions of a challenge of the current attempt
+func (m *model) showChallenge(msg authChallengeMsg) {
+	if msg.prompter != m.prompter {
+		close(msg.challenge.answers)
+		return
+	}
+	c := msg.challenge
+	m.authChallenge = c
+	m.authInputs = make([]textinput.Model, len(c.Questions))
+	for i, q := range c.Questions {
+		ti := textinput.New()
+		ti.Prompt = q
+		if !strings.HasSuffix(q, " ") {
+			ti.Prompt += " "
+		}
+		ti.CharLimit = 256
+		ti.Width = 40
+		if !c.Echos[i] {
+			ti.EchoMode = textinput.EchoPassword
+			ti.EchoCharacter = '•'
+		}
+		m.authInputs[i] = ti
+	}
+	m.authInputs[0].Focus()
+	m.authFocus = 0
+	m.state = authPromptView
+}
+
+func (m model) updateAuthPromptView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	c := m.authChallenge
+	switch msg.String() {
+	case "ctrl+c":
+		close(c.answers)
+		return m, tea.Quit
+
+	case "esc":
+		close(c.answers)
+		m.authChallenge = nil
+		m.state = listView
+		return m, nil
+
+	case "tab", "shift+tab", "up", "down", "enter":
+		s := msg.String()
+		if s == "enter" && m.authFocus == len(m.authInputs)-1 {
+			answers := make([]string, len(m.authInputs))
+			for i, input := range m.authInputs {
+				answers[i] = input.Value()
+			}
+			c.answers <- answers
+			m.authChallenge = nil
+			m.state = listView
+			return m, waitForChallenge(m.prompter)
+		}
+		if s == "up" || s == "shift+tab" {
+			m.authFocus = (m.authFocus + len(m.authInputs) - 1) % len(m.authInputs)
+		} else {
+			m.authFocus = (m.authFocus + 1) % len(m.authInputs)
+		}
+		for i := range m.authInputs {
+			if i == m.authFocus {
+				m.authInputs[i].Focus()
+			} else {
+				m.authInputs[i].Blur()
+			}
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.authInputs[m.authFocus], cmd = m.authInputs[m.authFocus].Update(msg)
+	return m, cmd
+}
+
+func (m model) viewAuthPrompt() string {
+	c := m.authChallenge
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Authentication for "+c.Server) + "\n\n")
+	if c.Instruction != "" {
+		for _, line := range strings.Split(c.Instruction, "\n") {
+			b.WriteString(helpStyle.Render(line) + "\n")
+		}
+		b.WriteString("\n")
+	}
+	for _, input := range m.authInputs {
+		b.WriteString("  " + input.View() + "\n")
+	}
+	b.WriteString("\n" + helpStyle.Render("[enter] next / send • [tab] switch field • [esc] cancel"))
+	return b.String()
+}
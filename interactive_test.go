@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthPrompterChallenge(t *testing.T) {
+	server := &Server{Name: "web", Password: "hunter2"}
+
+	t.Run("stored password", func(t *testing.T) {
+		p := newAuthPrompter()
+		challenge := p.challenge(server)
+		if answers, err := challenge("", "", nil, nil); err != nil || len(answers) != 0 {
+			t.Errorf("no questions answered with %q, %v", answers, err)
+		}
+		answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+		if err != nil || strings.Join(answers, ",") != "hunter2" {
+			t.Errorf("password question answered with %q, %v", answers, err)
+		}
+
+		// Asked again, the stored password was wrong: ask the user
+		go func() {
+			c := <-p.challenges
+			c.answers <- []string{"typed"}
+		}()
+		answers, err = challenge("", "", []string{"Password: "}, []bool{false})
+		if err != nil || strings.Join(answers, ",") != "typed" {
+			t.Errorf("second password question answered with %q, %v", answers, err)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		questions []string
+		echos     []bool
+		answer    func(c *authChallenge)
+		want      string
+		wantErr   error
+	}{
+		{
+			name:      "one-time code",
+			questions: []string{"Verification code: "},
+			echos:     []bool{true},
+			answer:    func(c *authChallenge) { c.answers <- []string{"123456"} },
+			want:      "123456",
+		},
+		{
+			name:      "several questions",
+			questions: []string{"Password: ", "Code: "},
+			echos:     []bool{false, true},
+			answer:    func(c *authChallenge) { c.answers <- []string{"pw", "42"} },
+			want:      "pw,42",
+		},
+		{
+			name:      "cancelled",
+			questions: []string{"Code: "},
+			echos:     []bool{true},
+			answer:    func(c *authChallenge) { close(c.answers) },
+			wantErr:   errAuthCancelled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newAuthPrompter()
+			go func() {
+				c := <-p.challenges
+				if c.Server != "web" || c.Instruction != "Login\nEnter your code" {
+					t.Errorf("challenge for %q with instruction %q", c.Server, c.Instruction)
+				}
+				tt.answer(c)
+			}()
+			answers, err := p.challenge(server)("Login", "Enter your code", tt.questions, tt.echos)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("challenge() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := strings.Join(answers, ","); got != tt.want {
+				t.Errorf("challenge() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("attempt ended", func(t *testing.T) {
+		p := newAuthPrompter()
+		close(p.done)
+		if _, err := p.challenge(server)("", "", []string{"Code: "}, []bool{true}); !errors.Is(err, errAuthCancelled) {
+			t.Errorf("challenge() error = %v, want %v", err, errAuthCancelled)
+		}
+	})
+}
+
+func TestConnectKeyboardInteractive(t *testing.T) {
+	host, port := testSSHServer(t, &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Verification code: "}, []bool{true})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] != "123456" {
+				return nil, errors.New("wrong code")
+			}
+			return nil, nil
+		},
+	})
+
+	tests := []struct {
+		name        string
+		key         tea.KeyMsg // sent after typing the code
+		code        string
+		wantErr     string
+		wantMessage string
+	}{
+		{name: "right code", key: tea.KeyMsg{Type: tea.KeyEnter}, code: "123456"},
+		{name: "wrong code", key: tea.KeyMsg{Type: tea.KeyEnter}, code: "000000", wantErr: "unable to authenticate"},
+		{name: "cancelled", key: tea.KeyMsg{Type: tea.KeyEsc}, code: "123456", wantErr: errAuthCancelled.Error(), wantMessage: "Connection to web cancelled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := Server{Name: "web", Host: host, Port: port, Username: "deploy", HostKeyPolicy: hostKeyTOFU}
+			m := model{knownHosts: newKnownHostsStore(filepath.Join(t.TempDir(), "known_hosts"))}
+
+			cmds := m.startConnect("ssh", server)().(tea.BatchMsg)
+			connected := make(chan tea.Msg, 1)
+			go func() { connected <- cmds[0]() }()
+
+			msg, ok := cmds[1]().(authChallengeMsg)
+			if !ok {
+				t.Fatalf("no challenge, got %v", <-connected)
+			}
+			m.showChallenge(msg)
+			if m.state != authPromptView || m.authInputs[0].EchoMode != textinput.EchoNormal {
+				t.Fatalf("state %v; want the code asked in the clear", m.state)
+			}
+			m.authInputs[0].SetValue(tt.code)
+			next, _ := m.updateAuthPromptView(tt.key)
+			m = next.(model)
+
+			var done connectedMsg
+			select {
+			case msg := <-connected:
+				done = msg.(connectedMsg)
+			case <-time.After(5 * time.Second):
+				t.Fatal("connection did not finish")
+			}
+			defer done.close()
+			if tt.wantErr == "" && (done.err != nil || done.client == nil) {
+				t.Fatalf("connect error = %v, want a client", done.err)
+			}
+			if tt.wantErr != "" && (done.err == nil || !strings.Contains(done.err.Error(), tt.wantErr)) {
+				t.Fatalf("connect error = %v, want %q", done.err, tt.wantErr)
+			}
+			if tt.wantMessage != "" {
+				next, _ := m.connected(done)
+				if m := next.(model); m.message != tt.wantMessage {
+					t.Errorf("message = %q, want %q", m.message, tt.wantMessage)
+				}
+			}
+		})
+	}
+}
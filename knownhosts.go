@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key policies for Server.HostKeyPolicy
+const (
+	hostKeyStrict = "strict" // only hosts already in known_hosts are accepted
+	hostKeyTOFU   = "tofu"   // unknown hosts are trusted on first use, mismatches are asked
+	hostKeyAsk    = "ask"    // unknown hosts and mismatches are asked (default)
+)
+
+var hostKeyPolicies = []string{hostKeyStrict, hostKeyTOFU, hostKeyAsk}
+
+// knownHostsStore is the app's own known_hosts file in OpenSSH format
+type knownHostsStore struct {
+	path string
+}
+
+// hostKeyError is returned by the host key callback when the user has to
+// decide whether to trust the key presented by the server.
+type hostKeyError struct {
+	Hostname string
+	Key      ssh.PublicKey
+	Want     []knownhosts.KnownKey // accepted keys; non-empty on mismatch
+	Policy   string
+}
+
+func (e *hostKeyError) Error() string {
+	if e.Mismatch() {
+		return fmt.Sprintf("host key mismatch for %s (got %s)", e.Hostname, ssh.FingerprintSHA256(e.Key))
+	}
+	return fmt.Sprintf("unknown host key for %s (%s)", e.Hostname, ssh.FingerprintSHA256(e.Key))
+}
+
+// Mismatch reports whether the host was known with a different key
+func (e *hostKeyError) Mismatch() bool {
+	return len(e.Want) > 0
+}
+
+// Promptable reports whether the user may be asked to accept the key
+func (e *hostKeyError) Promptable() bool {
+	return e.Policy != hostKeyStrict
+}
+
+func newKnownHostsStore(path string) *knownHostsStore {
+	return &knownHostsStore{path: path}
+}
+
+// normalizeHostKeyPolicy maps an empty or unknown policy to the default
+func normalizeHostKeyPolicy(policy string) string {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	for _, p := range hostKeyPolicies {
+		if policy == p {
+			return p
+		}
+	}
+	return hostKeyAsk
+}
+
+// ensure creates an empty known_hosts file if missing
+func (s *knownHostsStore) ensure() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// callback returns a host key callback that enforces the given policy
+func (s *knownHostsStore) callback(policy string) (ssh.HostKeyCallback, error) {
+	if err := s.ensure(); err != nil {
+		return nil, err
+	}
+	check, err := knownhosts.New(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	policy = normalizeHostKeyPolicy(policy)
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			// Revoked keys are never accepted
+			return err
+		}
+		if len(keyErr.Want) == 0 && policy == hostKeyTOFU {
+			return s.add(hostname, key)
+		}
+		return &hostKeyError{
+			Hostname: hostname,
+			Key:      key,
+			Want:     keyErr.Want,
+			Policy:   policy,
+		}
+	}, nil
+}
+
+// knownAlgorithms returns the host key algorithms already stored for the
+// address, so the handshake negotiates a key type we can actually verify.
+func (s *knownHostsStore) knownAlgorithms(address string) []string {
+	check, err := knownhosts.New(s.path)
+	if err != nil {
+		return nil
+	}
+
+	// Checking a throwaway key makes knownhosts report every stored key
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(check(address, &net.TCPAddr{}, probe), &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	for _, k := range keyErr.Want {
+		switch k.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algos = append(algos, k.Key.Type())
+		}
+	}
+	return algos
+}
+
+// add appends a key for the host to the store
+func (s *knownHostsStore) add(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{hostname}, key))
+	return err
+}
+
+// replace drops every existing entry for the host and stores the new key
+func (s *knownHostsStore) replace(hostname string, key ssh.PublicKey) error {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	host := knownhosts.Normalize(hostname)
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !knownHostsLineMatches(line, host) {
+			kept.WriteString(line + "\n")
+		}
+	}
+	kept.WriteString(knownhosts.Line([]string{hostname}, key) + "\n")
+
+	return ioutil.WriteFile(s.path, kept.Bytes(), 0600)
+}
+
+// knownHostsLineMatches reports whether a plain key line lists the host,
+// either literally or as a hashed (|1|salt|hash) entry. Comments and marker
+// lines (@revoked, @cert-authority) never match.
+func knownHostsLineMatches(line, host string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") {
+		return false
+	}
+
+	for _, pattern := range strings.Split(fields[0], ",") {
+		if pattern == host {
+			return true
+		}
+		if strings.HasPrefix(pattern, "|1|") {
+			parts := strings.Split(pattern[3:], "|")
+			if len(parts) != 2 {
+				continue
+			}
+			salt, err := base64.StdEncoding.DecodeString(parts[0])
+			if err != nil {
+				continue
+			}
+			mac := hmac.New(sha1.New, salt)
+			mac.Write([]byte(host))
+			if base64.StdEncoding.EncodeToString(mac.Sum(nil)) == parts[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trust records the key from a confirmed host key dialog
+func (s *knownHostsStore) trust(e *hostKeyError) error {
+	if e.Mismatch() {
+		return s.replace(e.Hostname, e.Key)
+	}
+	return s.add(e.Hostname, e.Key)
+}
+
+// --- Host key dialog ---
+
+func (m model) updateHostKeyView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+
+	case "y", "Y":
+		if err := m.knownHosts.trust(m.pendingHostKey); err != nil {
+			m.state = listView
+			m.message = fmt.Sprintf("Error saving host key: %v", err)
+			return m, nil
+		}
+		m.pendingHostKey = nil
+		m.state = listView
+		return m.retryPendingAction()
+
+	case "n", "N", "esc", "q":
+		m.message = fmt.Sprintf("Host key for %s rejected", m.pendingHostKey.Hostname)
+		m.pendingHostKey = nil
+		m.state = listView
+		return m, nil
+	}
+
+	return m, nil
+}
+
+// retryPendingAction re-runs the connection that was interrupted by a dialog
+func (m model) retryPendingAction() (tea.Model, tea.Cmd) {
+	switch m.pendingAction {
+	case "ssh":
+		return m.openSSH(m.pendingServer)
+	case "sftp":
+		return m.openSFTP(m.pendingServer)
+	case "sftp-pair":
+		return m.openSFTPPair(m.pendingPair[0], m.pendingPair[1])
+	}
+	return m, nil
+}
+
+// handleConnectError shows the host key dialog for promptable host key
+// errors, or the passphrase prompt for encrypted keys, and reports whether
+// it did so.
+func (m *model) handleConnectError(err error, action string, server Server) bool {
+	if m.handlePassphraseError(err, action, server) {
+		return true
+	}
+	var hkErr *hostKeyError
+	if !errors.As(err, &hkErr) || !hkErr.Promptable() {
+		return false
+	}
+	m.pendingHostKey = hkErr
+	m.pendingAction = action
+	m.pendingServer = server
+	m.state = hostKeyView
+	m.message = ""
+	return true
+}
+
+func (m model) viewHostKey() string {
+	var b strings.Builder
+	e := m.pendingHostKey
+
+	if e.Mismatch() {
+		b.WriteString(errorStyle.Render("WARNING: HOST KEY HAS CHANGED") + "\n\n")
+		b.WriteString(helpStyle.Render("The key presented by the server differs from the one saved in known_hosts.") + "\n")
+		b.WriteString(helpStyle.Render("Someone could be intercepting the connection, or the host was reinstalled.") + "\n\n")
+	} else {
+		b.WriteString(titleStyle.Render("Unknown Host") + "\n\n")
+		b.WriteString(helpStyle.Render("This host has not been seen before. Verify the fingerprint out of band.") + "\n\n")
+	}
+
+	fmt.Fprintf(&b, "  Host:        %s\n", e.Hostname)
+	fmt.Fprintf(&b, "  Key type:    %s\n", e.Key.Type())
+	fmt.Fprintf(&b, "  Fingerprint: %s\n", ssh.FingerprintSHA256(e.Key))
+	for _, want := range e.Want {
+		fmt.Fprintf(&b, "  Expected:    %s %s (%s:%d)\n", want.Key.Type(), ssh.FingerprintSHA256(want.Key), want.Filename, want.Line)
+	}
+
+	b.WriteString("\n")
+	if e.Mismatch() {
+		b.WriteString(helpStyle.Render("[y] replace saved key and connect • [n] abort"))
+	} else {
+		b.WriteString(helpStyle.Render("[y] trust and connect • [n] abort"))
+	}
+
+	return b.String()
+}
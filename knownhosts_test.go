@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKnownHostsLineMatches(t *testing.T) {
+	key := newTestPublicKey(t)
+	line := func(pattern string) string {
+		return pattern + " " + strings.SplitN(knownhosts.Line([]string{"x"}, key), " ", 2)[1]
+	}
+
+	tests := []struct {
+		name string
+		line string
+		host string
+		want bool
+	}{
+		{"plain", line("example.com"), "example.com", true},
+		{"other host", line("example.com"), "example.org", false},
+		{"one of several", line("a.example.com,example.com,10.0.0.1"), "example.com", true},
+		{"port entry", line("[example.com]:2222"), "[example.com]:2222", true},
+		{"port entry, default port", line("[example.com]:2222"), "example.com", false},
+		{"other port", line("[example.com]:2222"), "[example.com]:2200", false},
+		{"hashed", line(knownhosts.HashHostname("example.com")), "example.com", true},
+		{"hashed, other host", line(knownhosts.HashHostname("example.com")), "example.org", false},
+		{"hashed port entry", line(knownhosts.HashHostname("[example.com]:2222")), "[example.com]:2222", true},
+		{"hashed port entry, default port", line(knownhosts.HashHostname("[example.com]:2222")), "example.com", false},
+		{"broken hash", line("|1|notbase64|x"), "example.com", false},
+		{"comment", "# " + line("example.com"), "example.com", false},
+		{"revoked marker", "@revoked " + line("example.com"), "example.com", false},
+		{"cert authority marker", "@cert-authority " + line("example.com"), "example.com", false},
+		{"empty", "", "example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := knownHostsLineMatches(tt.line, tt.host); got != tt.want {
+				t.Errorf("knownHostsLineMatches(%q, %q) = %v, want %v", tt.line, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownHostsReplace(t *testing.T) {
+	oldKey, newKey, otherKey := newTestPublicKey(t), newTestPublicKey(t), newTestPublicKey(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	kept := []string{
+		"# managed by hand",
+		knownhosts.Line([]string{"other.example.com"}, otherKey),
+		knownhosts.Line([]string{"[example.com]:2200"}, otherKey),
+		"@revoked " + knownhosts.Line([]string{"example.com"}, otherKey),
+	}
+	replaced := []string{
+		knownhosts.Line([]string{"example.com"}, oldKey),
+		knownhosts.Line([]string{knownhosts.HashHostname("example.com")}, oldKey),
+	}
+	lines := append(append([]string{}, kept...), replaced...)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newKnownHostsStore(path)
+	if err := store.replace("example.com:22", newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(append(kept, knownhosts.Line([]string{"example.com:22"}, newKey)), "\n") + "\n"
+	if string(data) != want {
+		t.Errorf("known_hosts after replace:\n%s\nwant:\n%s", data, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("known_hosts mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestKnownHostsReplaceMissingFile(t *testing.T) {
+	key := newTestPublicKey(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := newKnownHostsStore(path).replace("example.com:22", key); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := knownhosts.Line([]string{"example.com:22"}, key) + "\n"; string(data) != want {
+		t.Errorf("known_hosts = %q, want %q", data, want)
+	}
+}
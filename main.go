@@ -1,18 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
@@ -27,18 +30,50 @@ type Server struct {
 	Password string `json:"password"`
 	PemKey   string `json:"pem_key"` // PEM private key as text
 	SFTPPort int    `json:"sftp_port"` // SFTP port (usually same as SSH port)
+	// Host key policy: "strict", "tofu" or "ask" (default)
+	HostKeyPolicy string `json:"host_key_policy,omitempty"`
+	// Group the server is filed under, 0 for the top level
+	GroupID int `json:"group_id,omitempty"`
+	// Free-form labels such as "env:prod" or "role:db"
+	Tags []string `json:"tags,omitempty"`
+	// Identity whose key is used when neither Password nor PemKey is set
+	IdentityID int `json:"identity_id,omitempty"`
+	// Passphrase of an encrypted PemKey
+	KeyPassphrase string `json:"key_passphrase,omitempty"`
+	// SHA256 fingerprint of the ssh-agent key to use, empty for any
+	AgentKey string `json:"agent_key,omitempty"`
+	// Make the local ssh-agent available in terminal sessions
+	ForwardAgent bool `json:"forward_agent,omitempty"`
 }
 
 // Config holds all servers and keychains
 type Config struct {
 	Servers []Server `json:"servers"`
 	NextID  int      `json:"next_id"`
+	// Number of concurrent SFTP transfers (0 means the default)
+	TransferWorkers int `json:"transfer_workers,omitempty"`
+	// Compare SHA-256 checksums after each copied file
+	VerifyTransfers bool `json:"verify_transfers,omitempty"`
+	// Copy symlinks as symlinks instead of the files they point to
+	CopyLinks bool `json:"copy_links,omitempty"`
+	// Commands run from the command panel by server ID, oldest first
+	CommandHistory map[int][]string `json:"command_history,omitempty"`
+	// Folders of servers, see Group
+	Groups []Group `json:"groups,omitempty"`
+	// Private keys shared by servers, see Identity
+	Identities []Identity `json:"identities,omitempty"`
 }
 
 // Implement list.Item interface for Server
-func (s Server) FilterValue() string { return s.Name }
+func (s Server) FilterValue() string { return strings.TrimSpace(s.Name + " " + strings.Join(s.Tags, " ")) }
 func (s Server) Title() string       { return s.Name }
-func (s Server) Description() string { return fmt.Sprintf("%s@%s:%d", s.Username, s.Host, s.Port) }
+func (s Server) Description() string {
+	desc := fmt.Sprintf("%s@%s:%d", s.Username, s.Host, s.Port)
+	if len(s.Tags) > 0 {
+		desc += " • " + strings.Join(s.Tags, " ")
+	}
+	return desc
+}
 
 // View states
 type viewState int
@@ -52,9 +87,26 @@ const (
 	filePickerView
 	sftpView
 	sftpFilePickerView
+	unlockView
+	vaultSetupView
+	hostKeyView
+	syncView
+	viewerView
+	followView
+	commandView
+	broadcastView
+	groupFormView
+	groupPickView
+	identitiesView
+	identityFormView
+	identityPickView
+	passphraseView
+	authPromptView
 )
 
 type model struct {
+	width       int
+	height      int
 	state       viewState
 	list        list.Model
 	config      *Config
@@ -76,14 +128,84 @@ type model struct {
 	// SFTP split-screen fields
 	selectedServer       *Server
 	sftpManager          *SFTPManager
+	leftServer           *Server      // server in the left pane; nil for the local disk
+	leftManager          *SFTPManager // connection for leftServer
+	pairSource           *Server      // first server picked for remote-to-remote
+	pendingPair          [2]Server    // servers of the last remote-to-remote attempt
 	localFileList        list.Model
 	remoteFileList       list.Model
 	localPath            string
 	remotePath           string
+	localMarked          markSet // marked entries, shared with the list delegates
+	remoteMarked         markSet
+	sftpSort             sortMode
+	sftpSortDesc         bool
 	focusPane            string // "local" or "remote"
 	transferProgress     int    // 0-100
 	isTransferring       bool
 	transferMessage      string
+	transferStatus       transferProgressMsg // bytes, rate and ETA of the running transfers
+	transferBar          progress.Model
+	transferQueue        *transferQueue
+	queueCursor          int
+	filePrompt           string // open file operation prompt, "" if none
+	filePromptTarget     string // entry the prompt applies to
+	filePromptInput      textinput.Model
+	pendingDelete        *deletePlan        // delete waiting for confirmation
+	deleteCancel         context.CancelFunc // stops a delete being measured or run
+	deleteRemoved        *atomic.Int64      // entries removed by the running delete
+	pendingEdit          *remoteEdit        // edit waiting for a conflict decision
+	syncMode             syncMode
+	syncDeletes          bool
+	syncChecksum         bool
+	syncSeq              int             // numbers comparisons so stale results are dropped
+	syncCompare          *syncComparison // nil while comparing
+	syncPlan             *syncPlan
+	syncCancel           context.CancelFunc // stops the deletes of a running sync
+	syncViewport         viewport.Model
+	viewer               *fileViewer // file open in the viewer
+	follower             *logFollower
+	followSeq            int // numbers followers so polls of closed ones are dropped
+	commandPanel         *commandPanel
+	serverMarks          serverMarks // marked servers, shared with the list delegate
+	broadcast            *broadcast
+	editingGroupID       int // group in the group form, 0 when adding one
+	groupParentID        int // where the group form adds a group
+	formGroupID          int // group a server added from the form goes into
+	moveServerIDs        []int
+	moveGroupID          int // group being moved, 0 when moving servers
+	groupChoices         []groupChoice
+	groupCursor          int
+	tagFilter            []string // the list shows servers carrying all of these
+	tagFocus             bool     // the tag facet bar has the keys
+	tagCursor            int
+	identityCursor       int
+	editingIdentityID    int // identity in the identity form, 0 when adding one
+	formIdentityID       int // identity picked in the server form
+	identityChoices      []identityChoice
+	identityPickCursor   int
+	identityAgentErr     string // why agent keys are missing from the picker
+	formAgentKey         string // agent key picked in the server form
+	identityReturn       viewState // form the identity picker returns to
+	// Vault fields
+	vault        *vault     // nil while the config is stored as plaintext
+	sealedConfig *vaultFile // encrypted config waiting to be unlocked
+	vaultInputs  []textinput.Model
+	vaultFocus   int
+	// Host key verification fields
+	knownHosts     *knownHostsStore
+	pendingHostKey *hostKeyError
+	pendingAction  string // connection to retry after a dialog: "ssh", "sftp" or "sftp-pair"
+	pendingServer  Server
+	keyPrompt      *keyPrompt
+	keyPassphrases map[string]string // passphrases entered this session, by private key
+	// Background connection fields
+	connecting    bool
+	connectSeq    int // numbers attempts so abandoned ones are dropped
+	prompter      *authPrompter
+	authChallenge *authChallenge
+	authInputs    []textinput.Model
+	authFocus     int
 }
 
 var (
@@ -110,28 +232,30 @@ var (
 var (
 	fileItemStyle     = lipgloss.NewStyle().PaddingLeft(2)
 	fileSelectedStyle = lipgloss.NewStyle().PaddingLeft(1).Foreground(lipgloss.Color("170"))
+	fileMarkedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
 )
 
 func initialModel() model {
 	configPath := filepath.Join(os.Getenv("HOME"), ".termius-from-walmart", "config.json")
-	config := loadConfig(configPath)
+	config, sealed := loadConfig(configPath)
 
-	items := make([]list.Item, len(config.Servers))
-	for i, server := range config.Servers {
-		items[i] = server
-	}
+	items := config.listItems(nil)
 
-	l := list.New(items, list.NewDefaultDelegate(), 0, 0)
+	marks := serverMarks{}
+	l := list.New(items, serverDelegate{DefaultDelegate: list.NewDefaultDelegate(), marked: marks}, 0, 0)
 	l.Title = "SSH Connection Manager"
+	localMarked, remoteMarked := markSet{}, markSet{}
 	l.SetShowStatusBar(true)
 	l.SetFilteringEnabled(true)
 
-	return model{
-		state:       listView,
-		list:        l,
-		config:      config,
-		configPath:  configPath,
-		menuOptions: []string{"Import Servers", "Export Servers", "Back to List"},
+	m := model{
+		state:        listView,
+		list:         l,
+		config:       config,
+		configPath:   configPath,
+		sealedConfig: sealed,
+		knownHosts:   newKnownHostsStore(filepath.Join(filepath.Dir(configPath), "known_hosts")),
+		menuOptions:  []string{"Import Servers", "Export Servers", "Master Passphrase", "Identities", "Back to List"},
 		menuCursor:  0,
 		// create file picker list with compact delegate
 		filePickerList: func() list.Model {
@@ -143,28 +267,45 @@ func initialModel() model {
 		filePickerShowHidden: false,
 		// create local file list
 		localFileList: func() list.Model {
-			l := list.New([]list.Item{}, fileDelegate{}, 0, 0)
+			l := list.New([]list.Item{}, entryDelegate{marked: localMarked}, 0, 0)
 			l.SetShowStatusBar(false)
 			l.SetFilteringEnabled(false)
 			return l
 		}(),
 		// create remote file list
 		remoteFileList: func() list.Model {
-			l := list.New([]list.Item{}, fileDelegate{}, 0, 0)
+			l := list.New([]list.Item{}, entryDelegate{marked: remoteMarked}, 0, 0)
 			l.SetShowStatusBar(false)
 			l.SetFilteringEnabled(false)
 			return l
 		}(),
 		localPath:    os.Getenv("HOME"),
 		remotePath:   "/",
+		localMarked:  localMarked,
+		remoteMarked: remoteMarked,
+		serverMarks:  marks,
 		focusPane:    "local",
 		transferProgress: 0,
 		isTransferring:   false,
+		transferBar:      progress.New(progress.WithDefaultGradient()),
+		syncViewport:     newSyncViewport(),
 	}
+
+	// Encrypted configs must be unlocked first; plaintext configs holding
+	// credentials are offered a migration to the vault.
+	if sealed != nil {
+		m.state = unlockView
+		m.initUnlockInputs()
+	} else if config.hasSecrets() {
+		m.state = vaultSetupView
+		m.initVaultSetupInputs()
+	}
+
+	return m
 }
 
 func (m *model) initInputs() {
-	m.inputs = make([]textinput.Model, 7)
+	m.inputs = make([]textinput.Model, 12)
 
 	// Name
 	m.inputs[0] = textinput.New()
@@ -218,21 +359,60 @@ func (m *model) initInputs() {
 	m.inputs[6].Width = 40
 	m.inputs[6].Prompt = "SFTP: "
 
+	// Host key policy
+	m.inputs[7] = textinput.New()
+	m.inputs[7].Placeholder = "ask (strict, tofu or ask)"
+	m.inputs[7].CharLimit = 6
+	m.inputs[7].Width = 40
+	m.inputs[7].Prompt = "HKey: "
+
+	// Tags
+	m.inputs[8] = textinput.New()
+	m.inputs[8].Placeholder = "env:prod, role:db (optional)"
+	m.inputs[8].CharLimit = 200
+	m.inputs[8].Width = 40
+	m.inputs[8].Prompt = "Tags: "
+
+	// Identity, picked from a list
+	m.inputs[identityInput] = textinput.New()
+	m.inputs[identityInput].Placeholder = "none (press enter to pick an identity)"
+	m.inputs[identityInput].Width = 40
+	m.inputs[identityInput].Prompt = "Iden: "
+	m.formIdentityID, m.formAgentKey = 0, ""
+
+	// Passphrase of the PEM key
+	m.inputs[keyPassphraseInput] = newPassphraseInput("KeyP: ", "passphrase of the PEM key (optional, asked when connecting)")
+
+	// Agent forwarding
+	m.inputs[forwardAgentInput] = textinput.New()
+	m.inputs[forwardAgentInput].Placeholder = "no (forward ssh-agent: yes or no)"
+	m.inputs[forwardAgentInput].CharLimit = 3
+	m.inputs[forwardAgentInput].Width = 40
+	m.inputs[forwardAgentInput].Prompt = "FwdA: "
+
 	m.focusIndex = 0
 }
 
 func (m *model) populateInputsForEdit(server Server) {
 	m.inputs[0].SetValue(server.Name)
 	m.inputs[1].SetValue(server.Host)
-	m.inputs[2].SetValue(strconv.Itoa(server.Port))
+	// Ports left empty are inherited from the group or default to 22
+	if server.Port != 0 {
+		m.inputs[2].SetValue(strconv.Itoa(server.Port))
+	}
 	m.inputs[3].SetValue(server.Username)
 	m.inputs[4].SetValue(server.Password)
 	m.inputs[5].SetValue(server.PemKey)
-	if server.SFTPPort == 0 {
-		m.inputs[6].SetValue(strconv.Itoa(server.Port))
-	} else {
+	if server.SFTPPort != 0 {
 		m.inputs[6].SetValue(strconv.Itoa(server.SFTPPort))
 	}
+	m.inputs[7].SetValue(server.HostKeyPolicy)
+	m.inputs[8].SetValue(strings.Join(server.Tags, ", "))
+	m.setFormIdentity(server.IdentityID, server.AgentKey)
+	if server.ForwardAgent {
+		m.inputs[forwardAgentInput].SetValue("yes")
+	}
+	m.inputs[keyPassphraseInput].SetValue(server.KeyPassphrase)
 }
 
 func (m model) Init() tea.Cmd {
@@ -242,13 +422,110 @@ func (m model) Init() tea.Cmd {
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
 		h, v := lipgloss.NewStyle().GetFrameSize()
 		m.list.SetSize(msg.Width-h, msg.Height-v)
 		m.filePickerList.SetSize(msg.Width-h, msg.Height-v)
-		// For split screen, divide width by 2
-		halfWidth := (msg.Width - h - 2) / 2
-		m.localFileList.SetSize(halfWidth, msg.Height-v-8)
-		m.remoteFileList.SetSize(halfWidth, msg.Height-v-8)
+		m.resizeSFTPPanes()
+		m.resizeSyncView()
+		m.resizeViewer()
+		m.resizeFollower()
+		m.resizeCommandPanel()
+		m.resizeBroadcast()
+		m.transferBar.Width = msg.Width - h - 50
+		if m.transferBar.Width < 10 {
+			m.transferBar.Width = 10
+		}
+		return m, nil
+
+	case queueUpdateMsg:
+		if m.transferQueue == nil {
+			return m, nil
+		}
+		m.refreshTransferStatus()
+		finished := m.transferQueue.DrainFinished()
+		for _, job := range finished {
+			m.message = job.Message
+		}
+		if len(finished) > 0 && m.state == sftpView {
+			m.loadLocalFiles(m.localPath)
+			m.loadRemoteFiles(m.remotePath)
+		}
+		m.resizeSFTPPanes()
+		return m, waitForQueue(m.transferQueue)
+
+	case syncComparedMsg:
+		if m.state != syncView || msg.seq != m.syncSeq {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.state = sftpView
+			m.message = fmt.Sprintf("Error comparing directories: %v", msg.err)
+			return m, nil
+		}
+		m.syncCompare = msg.cmp
+		m.replanSync()
+		return m, nil
+
+	case syncDeletedMsg:
+		m.syncDeleted(msg)
+		return m, nil
+
+	case editFetchedMsg:
+		cmd := m.editFetched(msg)
+		return m, cmd
+
+	case editFinishedMsg:
+		cmd := m.editFinished(msg)
+		return m, cmd
+
+	case deleteMeasuredMsg:
+		m.deleteMeasured(msg)
+		return m, nil
+
+	case deleteProgressMsg:
+		cmd := m.deleteProgress(msg)
+		return m, cmd
+
+	case deleteDoneMsg:
+		m.deleteDone(msg)
+		return m, nil
+
+	case editSavedMsg:
+		m.editSaved(msg)
+		return m, nil
+
+	case broadcastResultMsg:
+		m.broadcastResult(msg)
+		return m, nil
+
+	case commandDoneMsg:
+		m.commandDone(msg)
+		return m, nil
+
+	case followTickMsg:
+		return m, m.followTicked(msg)
+
+	case followDataMsg:
+		return m, m.followData(msg)
+
+	case viewerLoadedMsg:
+		m.viewerLoaded(msg)
+		return m, nil
+
+	case connectedMsg:
+		return m.connected(msg)
+
+	case authChallengeMsg:
+		m.showChallenge(msg)
+		return m, nil
+
+	case sshSessionEndedMsg:
+		if msg.err != nil {
+			m.message = fmt.Sprintf("Error: session to %s ended: %v", msg.server, msg.err)
+		} else {
+			m.message = fmt.Sprintf("Disconnected from %s", msg.server)
+		}
 		return m, nil
 
 	case tea.KeyMsg:
@@ -265,6 +542,36 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.updatePemEditView(msg)
 		case sftpView:
 			return m.updateSFTPView(msg)
+		case unlockView:
+			return m.updateUnlockView(msg)
+		case vaultSetupView:
+			return m.updateVaultSetupView(msg)
+		case hostKeyView:
+			return m.updateHostKeyView(msg)
+		case syncView:
+			return m.updateSyncView(msg)
+		case viewerView:
+			return m.updateViewerView(msg)
+		case followView:
+			return m.updateFollowView(msg)
+		case commandView:
+			return m.updateCommandView(msg)
+		case broadcastView:
+			return m.updateBroadcastView(msg)
+		case groupFormView:
+			return m.updateGroupFormView(msg)
+		case groupPickView:
+			return m.updateGroupPickView(msg)
+		case identitiesView:
+			return m.updateIdentitiesView(msg)
+		case identityFormView:
+			return m.updateIdentityFormView(msg)
+		case identityPickView:
+			return m.updateIdentityPickView(msg)
+		case passphraseView:
+			return m.updatePassphraseView(msg)
+		case authPromptView:
+			return m.updateAuthPromptView(msg)
 		}
 	}
 
@@ -274,49 +581,109 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m model) updateListView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Only quitting or giving up while a connection is being set up
+	if m.connecting {
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc":
+			m.abandonConnect()
+		}
+		return m, nil
+	}
+
+	if m.tagFocus {
+		return m.updateTagBar(msg)
+	}
+
+	// Picking the second server of a remote-to-remote session
+	if m.pairSource != nil && m.list.FilterState() != list.Filtering {
+		switch msg.String() {
+		case "enter":
+			if server, ok := listServer(m.list.SelectedItem()); ok {
+				source := *m.pairSource
+				m.pairSource = nil
+				return m.openSFTPPair(source, server)
+			}
+			return m, nil
+		case "esc":
+			m.pairSource = nil
+			m.message = ""
+			return m, nil
+		}
+	}
+
 	switch msg.String() {
 	case "ctrl+c", "q":
 		return m, tea.Quit
 
 	case "a":
 		m.state = addView
+		m.formGroupID = m.contextGroup()
 		m.initInputs()
+		m.setInheritedPlaceholders(m.formGroupID)
 		m.message = ""
 		return m, nil
 
 	case "e":
-		if len(m.config.Servers) > 0 {
-			selected := m.list.SelectedItem()
-			if server, ok := selected.(Server); ok {
-				m.state = editView
-				m.editingID = server.ID
-				m.initInputs()
-				m.populateInputsForEdit(server)
-				m.message = ""
-				return m, nil
-			}
+		selected := m.list.SelectedItem()
+		if group, ok := selected.(groupItem); ok {
+			m.openGroupForm(group.ID, 0)
+			return m, nil
+		}
+		if server, ok := listServer(selected); ok {
+			m.state = editView
+			m.editingID = server.ID
+			m.initInputs()
+			m.setInheritedPlaceholders(server.GroupID)
+			m.populateInputsForEdit(server)
+			m.message = ""
+			return m, nil
 		}
 
 	case "d":
-		if len(m.config.Servers) > 0 {
-			selected := m.list.SelectedItem()
-			if server, ok := selected.(Server); ok {
-				m.deleteServer(server.ID)
-				m.message = fmt.Sprintf("Deleted server: %s", server.Name)
-				return m, nil
-			}
+		selected := m.list.SelectedItem()
+		if group, ok := selected.(groupItem); ok {
+			m.deleteGroup(group.ID)
+			m.message = fmt.Sprintf("Deleted group: %s", group.Name)
+			return m, nil
+		}
+		if server, ok := listServer(selected); ok {
+			m.deleteServer(server.ID)
+			m.message = fmt.Sprintf("Deleted server: %s", server.Name)
+			return m, nil
 		}
 
 	case "enter":
-		if len(m.config.Servers) > 0 {
-			selected := m.list.SelectedItem()
-			if server, ok := selected.(Server); ok {
-				return m, tea.Sequence(
-					tea.ExecProcess(m.connectSSH(server), func(err error) tea.Msg {
-						return err
-					}),
-				)
-			}
+		selected := m.list.SelectedItem()
+		if group, ok := selected.(groupItem); ok && m.list.FilterState() != list.Filtering {
+			m.toggleGroup(group.ID)
+			return m, nil
+		}
+		if server, ok := listServer(selected); ok {
+			return m.openSSH(server)
+		}
+
+	case "g":
+		// New group next to the selection
+		if m.list.FilterState() != list.Filtering {
+			m.openGroupForm(0, m.contextGroup())
+			return m, nil
+		}
+
+	case "M":
+		// Move the marked or selected servers, or the selected group
+		if m.list.FilterState() != list.Filtering {
+			m.startMove()
+			return m, nil
+		}
+
+	case "T":
+		// Narrow the list by tag
+		if m.list.FilterState() != list.Filtering && len(m.config.allTags()) > 0 {
+			m.tagFocus = true
+			m.message = ""
+			return m, nil
 		}
 
 	case "m":
@@ -326,27 +693,30 @@ func (m model) updateListView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case "s":
-		if len(m.config.Servers) > 0 {
-			selected := m.list.SelectedItem()
-			if server, ok := selected.(Server); ok {
-				m.selectedServer = &server
-				// Establish SFTP connection
-				sftpMgr, err := ConnectSFTP(&server)
-				if err != nil {
-					m.message = fmt.Sprintf("Error connecting to SFTP: %v", err)
-					return m, nil
-				}
-				m.sftpManager = sftpMgr
-				m.state = sftpView
-				m.localPath = os.Getenv("HOME")
-				m.remotePath = "/"
-				m.focusPane = "local"
-				m.message = ""
-				// Load files
-				m.loadLocalFiles(m.localPath)
-				m.loadRemoteFiles(m.remotePath)
-				return m, nil
-			}
+		if server, ok := listServer(m.list.SelectedItem()); ok {
+			return m.openSFTP(server)
+		}
+
+	case " ":
+		// Mark the server for a broadcast
+		if m.list.FilterState() != list.Filtering {
+			m.toggleServerMark()
+			return m, nil
+		}
+
+	case "b":
+		// Run a command on the marked servers
+		if m.list.FilterState() != list.Filtering && len(m.config.Servers) > 0 {
+			cmd := m.openBroadcast()
+			return m, cmd
+		}
+
+	case "t":
+		// Transfer between two servers: this one goes in the left pane
+		if server, ok := listServer(m.list.SelectedItem()); ok {
+			m.pairSource = &server
+			m.message = fmt.Sprintf("Transfer from %s: select the other server and press enter, esc to cancel", server.Name)
+			return m, nil
 		}
 	}
 
@@ -355,6 +725,66 @@ func (m model) updateListView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// openSSH connects to the server and hands the terminal to a shell session.
+// Servers without stored credentials authenticate with the keys of ssh-agent.
+func (m model) openSSH(server Server) (tea.Model, tea.Cmd) {
+	server = m.connectServer(server)
+	cmd := m.startConnect("ssh", server)
+	return m, cmd
+}
+
+// transferOptions returns the copy options from the config
+func (m model) transferOptions() transferOptions {
+	return transferOptions{Verify: m.config.VerifyTransfers, PreserveLinks: m.config.CopyLinks}
+}
+
+// openSFTP establishes an SFTP connection and switches to the split view
+// once it is up.
+func (m model) openSFTP(server Server) (tea.Model, tea.Cmd) {
+	server = m.connectServer(server)
+	cmd := m.startConnect("sftp", server)
+	return m, cmd
+}
+
+// openSFTPPair connects to two servers and shows them side by side, so
+// files can be copied between them through this client.
+func (m model) openSFTPPair(left, right Server) (tea.Model, tea.Cmd) {
+	left, right = m.connectServer(left), m.connectServer(right)
+	m.pendingPair = [2]Server{left, right}
+	cmd := m.startConnect("sftp-pair", left, right)
+	return m, cmd
+}
+
+// enterSFTPView switches to the split view with server in the right pane
+// and either the local disk or leftServer in the left pane.
+func (m model) enterSFTPView(leftMgr *SFTPManager, leftServer *Server, sftpMgr *SFTPManager, server Server) (tea.Model, tea.Cmd) {
+	m.selectedServer = &server
+	m.sftpManager = sftpMgr
+	m.leftServer = leftServer
+	m.leftManager = leftMgr
+	m.transferQueue = newTransferQueue(m.leftFS(), sftpMgr, m.config.TransferWorkers, m.transferOptions())
+	m.queueCursor = 0
+	m.state = sftpView
+	m.remotePath = "/"
+	m.focusPane = "local"
+	m.message = ""
+	m.localMarked.clear()
+	m.remoteMarked.clear()
+	m.resizeSFTPPanes()
+	// Load files
+	m.loadLocalFiles(m.localPath)
+	m.loadRemoteFiles(m.remotePath)
+	return m, waitForQueue(m.transferQueue)
+}
+
+// leftFS returns the file system shown in the left pane
+func (m model) leftFS() fileSystem {
+	if m.leftManager != nil {
+		return m.leftManager
+	}
+	return localFS{}
+}
+
 func (m model) updateMenuView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "ctrl+c", "q":
@@ -390,7 +820,12 @@ func (m model) updateMenuView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			ti.Prompt = "Filename: "
 			m.filePickerInput = ti
 			m.loadFileList()
-		case 2: // Back
+		case 2: // Master passphrase
+			m.state = vaultSetupView
+			m.initVaultSetupInputs()
+		case 3: // Identities
+			m.openIdentities()
+		case 4: // Back
 			m.state = listView
 		}
 		return m, nil
@@ -458,6 +893,18 @@ func (m model) updateFormView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			}
 			return m, nil
 		}
+		if m.focusIndex == identityInput {
+			m.openIdentityPicker()
+			return m, nil
+		}
+	}
+
+	// The identity field is picked from a list, not typed
+	if m.focusIndex == identityInput {
+		if s := msg.String(); s == "backspace" || s == "delete" {
+			m.setFormIdentity(0, "")
+		}
+		return m, nil
 	}
 
 	cmd := m.updateInputs(msg)
@@ -527,6 +974,10 @@ func (m *model) validateAndSave() bool {
 	password := m.inputs[4].Value()
 	pemKey := m.inputs[5].Value()
 	sftpPortStr := strings.TrimSpace(m.inputs[6].Value())
+	hostKeyPolicy := strings.ToLower(strings.TrimSpace(m.inputs[7].Value()))
+	tags := parseTags(m.inputs[8].Value())
+	keyPassphrase := m.inputs[keyPassphraseInput].Value()
+	forward := strings.ToLower(strings.TrimSpace(m.inputs[forwardAgentInput].Value()))
 
 	if name == "" {
 		m.message = "Error: Name is required"
@@ -536,7 +987,15 @@ func (m *model) validateAndSave() bool {
 		m.message = "Error: Host is required"
 		return false
 	}
-	if username == "" {
+	groupID := m.formGroupID
+	if m.state == editView {
+		for _, server := range m.config.Servers {
+			if server.ID == m.editingID {
+				groupID = server.GroupID
+			}
+		}
+	}
+	if m.config.resolveServer(Server{GroupID: groupID, Username: username}).Username == "" {
 		m.message = "Error: Username is required"
 		return false
 	}
@@ -546,8 +1005,17 @@ func (m *model) validateAndSave() bool {
 		m.message = "Error: Use either password OR PEM key, not both"
 		return false
 	}
+	if (m.formIdentityID != 0 || m.formAgentKey != "") && (password != "" || pemKey != "") {
+		m.message = "Error: Use either an identity OR a password or PEM key"
+		return false
+	}
+	if forward != "" && forward != "yes" && forward != "no" {
+		m.message = "Error: Agent forwarding must be yes or no"
+		return false
+	}
 
-	port := 22
+	// Empty ports are stored as 0 and resolved when connecting
+	port := 0
 	if portStr != "" {
 		var err error
 		port, err = strconv.Atoi(portStr)
@@ -558,7 +1026,7 @@ func (m *model) validateAndSave() bool {
 	}
 
 	// Handle SFTP port (defaults to SSH port if not specified)
-	sftpPort := port
+	sftpPort := 0
 	if sftpPortStr != "" {
 		var err error
 		sftpPort, err = strconv.Atoi(sftpPortStr)
@@ -568,6 +1036,11 @@ func (m *model) validateAndSave() bool {
 		}
 	}
 
+	if hostKeyPolicy != "" && normalizeHostKeyPolicy(hostKeyPolicy) != hostKeyPolicy {
+		m.message = "Error: Host key policy must be strict, tofu or ask"
+		return false
+	}
+
 	// Validate PEM key format if provided
 	if pemKey != "" {
 		normalized := normalizePemKey(pemKey)
@@ -577,16 +1050,35 @@ func (m *model) validateAndSave() bool {
 		}
 	}
 
+	// Encrypted keys without a stored passphrase are unlocked when connecting
+	if keyPassphrase != "" {
+		if pemKey == "" {
+			m.message = "Error: Key passphrase is set but there is no PEM key"
+			return false
+		}
+		if _, err := parseSigner(pemKey, keyPassphrase); err != nil {
+			m.message = fmt.Sprintf("Error: %v", err)
+			return false
+		}
+	}
+
 	if m.state == addView {
 		server := Server{
-			ID:       m.config.NextID,
-			Name:     name,
-			Host:     host,
-			Port:     port,
-			Username: username,
-			Password: password,
-			PemKey:   pemKey,
-			SFTPPort: sftpPort,
+			ID:            m.config.NextID,
+			Name:          name,
+			Host:          host,
+			Port:          port,
+			Username:      username,
+			Password:      password,
+			PemKey:        pemKey,
+			SFTPPort:      sftpPort,
+			HostKeyPolicy: hostKeyPolicy,
+			GroupID:       groupID,
+			Tags:          tags,
+			IdentityID:    m.formIdentityID,
+			KeyPassphrase: keyPassphrase,
+			AgentKey:      m.formAgentKey,
+			ForwardAgent:  forward == "yes",
 		}
 		m.config.Servers = append(m.config.Servers, server)
 		m.config.NextID++
@@ -601,6 +1093,12 @@ func (m *model) validateAndSave() bool {
 				m.config.Servers[i].Password = password
 				m.config.Servers[i].PemKey = pemKey
 				m.config.Servers[i].SFTPPort = sftpPort
+				m.config.Servers[i].HostKeyPolicy = hostKeyPolicy
+				m.config.Servers[i].Tags = tags
+				m.config.Servers[i].IdentityID = m.formIdentityID
+				m.config.Servers[i].KeyPassphrase = keyPassphrase
+				m.config.Servers[i].AgentKey = m.formAgentKey
+				m.config.Servers[i].ForwardAgent = forward == "yes"
 				m.message = fmt.Sprintf("Updated server: %s", name)
 				break
 			}
@@ -624,16 +1122,15 @@ func (m *model) deleteServer(id int) {
 		}
 	}
 	m.config.Servers = newServers
+	delete(m.config.CommandHistory, id)
+	delete(m.serverMarks, id)
 	m.saveConfig()
 	m.refreshList()
 }
 
 func (m *model) refreshList() {
-	items := make([]list.Item, len(m.config.Servers))
-	for i, server := range m.config.Servers {
-		items[i] = server
-	}
-	m.list.SetItems(items)
+	m.pruneTagFilter()
+	m.list.SetItems(m.config.listItems(m.tagFilter))
 }
 
 // normalizePemKey converts escaped newlines, trims surrounding quotes/space,
@@ -690,63 +1187,58 @@ func normalizePemKey(pem string) string {
 	return clean
 }
 
-func (m *model) connectSSH(server Server) *exec.Cmd {
-	args := []string{
-		fmt.Sprintf("%s@%s", server.Username, server.Host),
-		"-p", strconv.Itoa(server.Port),
-	}
-
-	// If PEM key is provided, save it to a temporary file
-	if server.PemKey != "" {
-		// Create temp directory if it doesn't exist
-		tempDir := filepath.Join(os.TempDir(), "termius-from-walmart-keys")
-		os.MkdirAll(tempDir, 0700)
-
-		// Create temp key file
-		keyFile := filepath.Join(tempDir, fmt.Sprintf("key_%d.pem", server.ID))
-
-		// Clean and normalize the PEM key
-		cleanKey := normalizePemKey(server.PemKey)
+// exportData lists the servers as they are entered. What a server inherits
+// is not copied into it: its group is exported as a path and its identity
+// by name, so a shared key is not repeated for every server using it.
+func (c *Config) exportData() []map[string]interface{} {
+	exportData := make([]map[string]interface{}, len(c.Servers))
+	for i, server := range c.Servers {
+		identity := ""
+		if id := c.identity(server.IdentityID); id != nil {
+			identity = id.Name
+		}
+		exportData[i] = map[string]interface{}{
+			"group":           c.groupPath(server.GroupID),
+			"identity":        identity,
+			"name":            server.Name,
+			"host":            server.Host,
+			"port":            server.Port,
+			"username":        server.Username,
+			"password":        server.Password,
+			"pem_key":         server.PemKey,
+			"key_passphrase":  server.KeyPassphrase,
+			"agent_key":       server.AgentKey,
+			"forward_agent":   server.ForwardAgent,
+			"sftp_port":       server.SFTPPort,
+			"host_key_policy": server.HostKeyPolicy,
+			"tags":            server.Tags,
+		}
+	}
+	return exportData
+}
 
-		// Write the key file
-		if err := ioutil.WriteFile(keyFile, []byte(cleanKey), 0600); err == nil {
-			// Add SSH options for better compatibility
-			args = append([]string{
-				"-i", keyFile,
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "UserKnownHostsFile=/dev/null",
-				"-o", "IdentitiesOnly=yes",
-			}, args...)
-			return exec.Command("ssh", args...)
+// importRefs files an imported server under the group it was exported from,
+// creating the group if needed, and links the identity of the same name if
+// there is one.
+func (c *Config) importRefs(server *Server, item map[string]interface{}) {
+	if name, ok := item["identity"].(string); ok && name != "" {
+		for _, id := range c.Identities {
+			if id.Name == name {
+				server.IdentityID = id.ID
+				break
+			}
 		}
 	}
-
-	// If password is provided, use sshpass
-	if server.Password != "" {
-		return exec.Command("sshpass", append([]string{"-p", server.Password, "ssh"}, args...)...)
+	if group, ok := item["group"].(string); ok && group != "" {
+		server.GroupID = c.ensureGroupPath(group)
+		server.ID = c.NextID // new groups may have taken the ID
 	}
-
-	// Default: use system SSH keys
-	return exec.Command("ssh", args...)
 }
 
 func (m *model) exportServers() {
 	exportPath := filepath.Join(os.Getenv("HOME"), "ssh-servers-export.json")
 
-	exportData := make([]map[string]interface{}, len(m.config.Servers))
-	for i, server := range m.config.Servers {
-		exportData[i] = map[string]interface{}{
-			"name":      server.Name,
-			"host":      server.Host,
-			"port":      server.Port,
-			"username":  server.Username,
-			"password":  server.Password,
-			"pem_key":   server.PemKey,
-			"sftp_port": server.SFTPPort,
-		}
-	}
-
-	data, err := json.MarshalIndent(exportData, "", "  ")
+	data, err := json.MarshalIndent(m.config.exportData(), "", "  ")
 	if err != nil {
 		m.message = fmt.Sprintf("Export failed: %v", err)
 		return
@@ -798,12 +1290,38 @@ func (m *model) importServers() {
 			server.PemKey = pemKey
 		}
 
+		if passphrase, ok := item["key_passphrase"].(string); ok {
+			server.KeyPassphrase = passphrase
+		}
+
+		if agentKey, ok := item["agent_key"].(string); ok {
+			server.AgentKey = agentKey
+		}
+
+		if forward, ok := item["forward_agent"].(bool); ok {
+			server.ForwardAgent = forward
+		}
+
 		if sftpPort, ok := item["sftp_port"].(float64); ok {
 			server.SFTPPort = int(sftpPort)
 		} else {
 			server.SFTPPort = server.Port
 		}
 
+		if policy, ok := item["host_key_policy"].(string); ok {
+			server.HostKeyPolicy = policy
+		}
+
+		if tags, ok := item["tags"].([]interface{}); ok {
+			for _, tag := range tags {
+				if tag, ok := tag.(string); ok {
+					server.Tags = append(server.Tags, parseTags(tag)...)
+				}
+			}
+		}
+
+		m.config.importRefs(&server, item)
+
 		m.config.Servers = append(m.config.Servers, server)
 		m.config.NextID++
 		count++
@@ -1051,12 +1569,38 @@ func (m *model) importServersFromPath(importPath string) {
 			server.PemKey = pemKey
 		}
 
+		if passphrase, ok := item["key_passphrase"].(string); ok {
+			server.KeyPassphrase = passphrase
+		}
+
+		if agentKey, ok := item["agent_key"].(string); ok {
+			server.AgentKey = agentKey
+		}
+
+		if forward, ok := item["forward_agent"].(bool); ok {
+			server.ForwardAgent = forward
+		}
+
 		if sftpPort, ok := item["sftp_port"].(float64); ok {
 			server.SFTPPort = int(sftpPort)
 		} else {
 			server.SFTPPort = server.Port
 		}
 
+		if policy, ok := item["host_key_policy"].(string); ok {
+			server.HostKeyPolicy = policy
+		}
+
+		if tags, ok := item["tags"].([]interface{}); ok {
+			for _, tag := range tags {
+				if tag, ok := tag.(string); ok {
+					server.Tags = append(server.Tags, parseTags(tag)...)
+				}
+			}
+		}
+
+		m.config.importRefs(&server, item)
+
 		m.config.Servers = append(m.config.Servers, server)
 		m.config.NextID++
 		count++
@@ -1072,20 +1616,7 @@ func (m *model) importServersFromPath(importPath string) {
 }
 
 func (m *model) exportServersToPath(exportPath string) {
-	exportData := make([]map[string]interface{}, len(m.config.Servers))
-	for i, server := range m.config.Servers {
-		exportData[i] = map[string]interface{}{
-			"name":      server.Name,
-			"host":      server.Host,
-			"port":      server.Port,
-			"username":  server.Username,
-			"password":  server.Password,
-			"pem_key":   server.PemKey,
-			"sftp_port": server.SFTPPort,
-		}
-	}
-
-	data, err := json.MarshalIndent(exportData, "", "  ")
+	data, err := json.MarshalIndent(m.config.exportData(), "", "  ")
 	if err != nil {
 		m.message = fmt.Sprintf("Export failed: %v", err)
 		return
@@ -1115,12 +1646,54 @@ func (m model) View() string {
 		return m.viewPemEdit()
 	case sftpView:
 		return m.viewSFTP()
+	case unlockView:
+		return m.viewUnlock()
+	case vaultSetupView:
+		return m.viewVaultSetup()
+	case hostKeyView:
+		return m.viewHostKey()
+	case syncView:
+		return m.viewSync()
+	case viewerView:
+		return m.viewViewer()
+	case followView:
+		return m.viewFollow()
+	case commandView:
+		return m.viewCommand()
+	case broadcastView:
+		return m.viewBroadcast()
+	case groupFormView:
+		if m.editingGroupID == 0 {
+			return m.viewForm("Add Group")
+		}
+		return m.viewForm("Edit Group")
+	case groupPickView:
+		return m.viewGroupPick()
+	case identitiesView:
+		return m.viewIdentities()
+	case identityFormView:
+		if m.editingIdentityID == 0 {
+			return m.viewForm("Add Identity")
+		}
+		return m.viewForm("Edit Identity")
+	case identityPickView:
+		return m.viewIdentityPick()
+	case passphraseView:
+		return m.viewPassphrase()
+	case authPromptView:
+		return m.viewAuthPrompt()
 	}
 	return ""
 }
 
 func (m model) viewList() string {
-	help := helpStyle.Render("\nKeys: [a]dd • [e]dit • [d]elete • [enter] connect • [s]ftp • [m]enu • [q]uit")
+	help := helpStyle.Render("\nKeys: [a]dd • [e]dit • [d]elete • [enter] connect • [s]ftp • [t]ransfer between servers • [space] mark • [b]roadcast • [g]roup • [M]ove • [T]ags • [m]enu • [q]uit")
+	if n := len(m.serverMarks); n > 0 {
+		help = helpStyle.Render(fmt.Sprintf("\n%d servers marked for broadcast", n)) + help
+	}
+	if bar := m.viewTagBar(); bar != "" {
+		help = "\n" + bar + help
+	}
 
 	if m.message != "" {
 		msgStyle := messageStyle
@@ -1242,63 +1815,176 @@ func (m model) viewPemEdit() string {
 
 // SFTP View Functions
 func (m model) updateSFTPView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.filePrompt != "" {
+		return m.updateFilePrompt(msg)
+	}
+	if m.deleteCancel != nil {
+		return m.updateDeleteRunning(msg)
+	}
+	if m.pendingDelete != nil {
+		return m.updateDeleteConfirm(msg)
+	}
+	if m.pendingEdit != nil {
+		return m.updateEditConflict(msg)
+	}
+	if m.focusPane == "queue" && m.updateQueuePanel(msg) {
+		return m, nil
+	}
+
 	switch msg.String() {
 	case "ctrl+c", "q", "esc":
-		// Close SFTP and return to list
+		// Close SFTP and return to list; unfinished transfers are cancelled
+		if m.transferQueue != nil {
+			m.transferQueue.Close()
+		}
 		if m.sftpManager != nil {
 			m.sftpManager.Close()
 		}
+		if m.leftManager != nil {
+			m.leftManager.Close()
+		}
 		m.selectedServer = nil
 		m.sftpManager = nil
+		m.leftServer = nil
+		m.leftManager = nil
+		m.transferQueue = nil
+		m.isTransferring = false
 		m.state = listView
 		m.message = ""
 		return m, nil
 
 	case "tab":
-		// Switch between local and remote panes
-		if m.focusPane == "local" {
+		// Cycle between local pane, remote pane and the transfer queue
+		switch {
+		case m.focusPane == "local":
 			m.focusPane = "remote"
-		} else {
+		case m.focusPane == "remote" && m.queuePanelHeight() > 0:
+			m.focusPane = "queue"
+		default:
 			m.focusPane = "local"
 		}
 		return m, nil
 
 	case "enter":
-		// Navigate into directory or handle action
+		// Navigate into directory, or view a file
+		sel := m.localFileList.SelectedItem()
+		if m.focusPane == "remote" {
+			sel = m.remoteFileList.SelectedItem()
+		}
+		if sel == nil {
+			return m, nil
+		}
+		name := sel.(fileEntry).browseName()
+		if !strings.HasSuffix(name, "/") {
+			cmd := m.openViewer()
+			return m, cmd
+		}
 		if m.focusPane == "local" {
-			sel := m.localFileList.SelectedItem()
-			if sel == nil {
-				return m, nil
-			}
-			fileName := sel.FilterValue()
-			m.navigateLocalDir(fileName)
+			m.navigateLocalDir(name)
 		} else {
-			sel := m.remoteFileList.SelectedItem()
-			if sel == nil {
-				return m, nil
-			}
-			fileName := sel.FilterValue()
-			m.navigateRemoteDir(fileName)
+			m.navigateRemoteDir(name)
 		}
 		return m, nil
 
-	case "c":
-		// Copy from one side to the other
-		m.performCopy()
+	case "c", "m":
+		// Copy or move from one side to the other
+		cmd := m.performCopy(msg.String() == "m")
+		return m, cmd
+
+	case "s":
+		// Cycle the sort order between name, size and date
+		m.setSort((m.sftpSort+1)%3, m.sftpSortDesc)
+		return m, nil
+
+	case "S":
+		// Reverse the sort order
+		m.setSort(m.sftpSort, !m.sftpSortDesc)
 		return m, nil
 
+	case "e":
+		// Edit the selected file in $EDITOR
+		cmd := m.startEdit()
+		return m, cmd
+
+	case "f":
+		// Follow the selected file like tail -f
+		cmd := m.startFollow()
+		return m, cmd
+
+	case "!":
+		// Run a command on the server
+		cmd := m.openCommandPanel()
+		return m, cmd
+
+	case "y":
+		// Compare both directories and preview a sync
+		cmd := m.startSync()
+		return m, cmd
+
+	case " ":
+		// Mark or unmark the selected entry
+		m.toggleMark()
+		return m, nil
+
+	case "*":
+		// Invert the marks of the focused pane
+		m.invertMarks()
+		return m, nil
+
+	case "+":
+		// Mark entries matching a pattern
+		cmd := m.startFilePrompt(promptSelect)
+		return m, cmd
+
 	case "d":
-		// Delete selected file
-		if m.focusPane == "local" {
-			m.deleteLocalFile()
+		// Delete selected file or directory after confirmation
+		cmd := m.startDelete()
+		return m, cmd
+
+	case "r":
+		// Rename selected file
+		cmd := m.startFilePrompt(promptRename)
+		return m, cmd
+
+	case "n":
+		// Create a directory in the focused pane
+		cmd := m.startFilePrompt(promptMkdir)
+		return m, cmd
+
+	case "p":
+		// Change permissions of the selected file
+		cmd := m.startFilePrompt(promptChmod)
+		return m, cmd
+
+	case "o":
+		// Change owner and group of the selected file
+		cmd := m.startFilePrompt(promptChown)
+		return m, cmd
+
+	case "v":
+		// Toggle checksum verification of copied files
+		m.config.VerifyTransfers = !m.config.VerifyTransfers
+		m.transferQueue.SetOptions(m.transferOptions())
+		if err := m.saveConfig(); err != nil {
+			m.message = fmt.Sprintf("Error saving: %v", err)
+		} else if m.config.VerifyTransfers {
+			m.message = "Checksum verification on"
 		} else {
-			m.deleteRemoteFile()
+			m.message = "Checksum verification off"
 		}
 		return m, nil
 
-	case "r":
-		// Rename selected file
-		m.message = "Rename not yet implemented"
+	case "L":
+		// Toggle copying symlinks as links or as what they point to
+		m.config.CopyLinks = !m.config.CopyLinks
+		m.transferQueue.SetOptions(m.transferOptions())
+		if err := m.saveConfig(); err != nil {
+			m.message = fmt.Sprintf("Error saving: %v", err)
+		} else if m.config.CopyLinks {
+			m.message = "Copying symlinks as links"
+		} else {
+			m.message = "Copying the files symlinks point to"
+		}
 		return m, nil
 	}
 
@@ -1320,18 +2006,31 @@ func (m model) viewSFTP() string {
 	}
 
 	headerStyle := titleStyle
-	b.WriteString(headerStyle.Render(fmt.Sprintf("SFTP: %s@%s", server.Username, server.Host)) + "\n")
+	title := fmt.Sprintf("SFTP: %s@%s", server.Username, server.Host)
+	if left := m.leftServer; left != nil {
+		title = fmt.Sprintf("SFTP: %s@%s ⇄ %s@%s", left.Username, left.Host, server.Username, server.Host)
+	}
+	b.WriteString(headerStyle.Render(title) + "\n")
 
 	// Progress indicator if transferring
 	if m.isTransferring {
-		progressBar := fmt.Sprintf("[%d%%]", m.transferProgress)
-		b.WriteString(messageStyle.Render(progressBar) + " " + m.transferMessage + "\n")
+		percent := 0.0
+		if m.transferStatus.total > 0 {
+			percent = float64(m.transferStatus.done) / float64(m.transferStatus.total)
+		}
+		b.WriteString("  " + m.transferBar.ViewAs(percent) + " " + helpStyle.Render(formatTransferStatus(m.transferStatus)) + "\n")
+		b.WriteString(messageStyle.Render(m.transferMessage) + "\n")
 	}
 	b.WriteString("\n")
 
 	// Path headers
 	localHeader := "LOCAL"
 	remoteHeader := "REMOTE"
+	if m.leftServer != nil {
+		localHeader, remoteHeader = strings.ToUpper(m.leftServer.Name), strings.ToUpper(server.Name)
+	}
+	localHeader += markSummary(m.localMarked, m.localFileList.Items())
+	remoteHeader += markSummary(m.remoteMarked, m.remoteFileList.Items())
 	if m.focusPane == "local" {
 		localHeader = "> " + localHeader + " <"
 	} else {
@@ -1364,14 +2063,30 @@ func (m model) viewSFTP() string {
 		}
 
 		// Ensure proper spacing
-		if len(localLine) < 40 {
-			localLine += strings.Repeat(" ", 40-len(localLine))
+		if width := lipgloss.Width(localLine); width < m.localFileList.Width() {
+			localLine += strings.Repeat(" ", m.localFileList.Width()-width)
 		}
 
 		b.WriteString(localLine + " | " + remoteLine + "\n")
 	}
 
-	b.WriteString("\n" + helpStyle.Render("Keys: [Tab] switch pane • [c]opy • [d]elete • [enter] navigate • [q]uit"))
+	if m.queuePanelHeight() > 0 {
+		b.WriteString("\n" + m.viewTransferQueue())
+	}
+
+	if m.filePrompt != "" {
+		b.WriteString("\n  " + m.viewFilePrompt())
+	} else if m.deleteCancel != nil {
+		b.WriteString("\n" + helpStyle.Render("[esc] stop"))
+	} else if m.pendingDelete != nil {
+		b.WriteString("\n" + m.viewDeleteConfirm())
+	} else if m.pendingEdit != nil {
+		b.WriteString("\n" + m.viewEditConflict())
+	} else if m.focusPane == "queue" {
+		b.WriteString("\n" + helpStyle.Render("Queue: [p]ause/resume • [x] cancel • [r]etry • [C]lear finished • [+/-] workers • [Tab] switch pane"))
+	} else {
+		b.WriteString("\n" + helpStyle.Render("Keys: [Tab] switch pane • [space] mark • [*] invert • [+] mark pattern • [c]opy • [m]ove • [e]dit • [f]ollow • [d]elete • [r]ename • [n]ew dir • [p]erms • [o]wner • [s]ort • [y] sync • [!] command • [v]erify • [L]inks • [enter] open • [q]uit"))
+	}
 
 	if m.message != "" {
 		msgStyle := messageStyle
@@ -1384,31 +2099,34 @@ func (m model) viewSFTP() string {
 	return b.String()
 }
 
+// resizeSFTPPanes fits the file lists between the header, the progress
+// bar and the queue panel.
+func (m *model) resizeSFTPPanes() {
+	h, v := lipgloss.NewStyle().GetFrameSize()
+	// For split screen, divide width by 2
+	halfWidth := (m.width - h - 2) / 2
+	height := m.height - v - 8 - m.queuePanelHeight()
+	if m.isTransferring {
+		height -= 2
+	}
+	if height < 3 {
+		height = 3
+	}
+	m.localFileList.SetSize(halfWidth, height)
+	m.remoteFileList.SetSize(halfWidth, height)
+}
+
 func (m *model) loadLocalFiles(path string) {
-	entries, err := ioutil.ReadDir(path)
+	files, err := listEntries(m.leftFS(), path)
 	if err != nil {
 		m.message = fmt.Sprintf("Error reading local directory: %v", err)
 		m.localFileList.SetItems([]list.Item{})
 		return
 	}
 
-	items := make([]list.Item, 0, len(entries)+1)
-
-	// Add parent directory
-	if path != "/" {
-		items = append(items, fileItem("../"))
-	}
-
-	// Add files and directories
-	for _, f := range entries {
-		name := f.Name()
-		if f.IsDir() {
-			name = name + "/"
-		}
-		items = append(items, fileItem(name))
-	}
-
+	items := entryItems(files, path, m.sftpSort, m.sftpSortDesc)
 	m.localFileList.SetItems(items)
+	pruneMarks(m.localMarked, items)
 	m.localPath = path
 }
 
@@ -1418,41 +2136,25 @@ func (m *model) loadRemoteFiles(path string) {
 		return
 	}
 
-	files, err := m.sftpManager.ListFiles(path)
+	files, err := listEntries(m.sftpManager, path)
 	if err != nil {
 		m.message = fmt.Sprintf("Error listing remote files: %v", err)
 		m.remoteFileList.SetItems([]list.Item{})
 		return
 	}
 
-	items := make([]list.Item, 0, len(files)+1)
-
-	// Add parent directory
-	if path != "/" {
-		items = append(items, fileItem("../"))
-	}
-
-	// Add files and directories
-	for _, f := range files {
-		name := f.Name()
-		if f.IsDir() {
-			name = name + "/"
-		}
-		items = append(items, fileItem(name))
-	}
-
+	items := entryItems(files, path, m.sftpSort, m.sftpSortDesc)
 	m.remoteFileList.SetItems(items)
+	pruneMarks(m.remoteMarked, items)
 	m.remotePath = path
 }
 
 func (m *model) navigateLocalDir(fileName string) {
 	if strings.HasSuffix(fileName, "/") {
 		dirName := strings.TrimSuffix(fileName, "/")
-		if dirName == "../" {
-			m.localPath = filepath.Dir(m.localPath)
-		} else {
-			m.localPath = filepath.Join(m.localPath, dirName)
-		}
+		// Join cleans "..", and uses forward slashes for a remote left pane
+		m.localPath = m.leftFS().Join(m.localPath, dirName)
+		m.localMarked.clear()
 		m.loadLocalFiles(m.localPath)
 	}
 }
@@ -1474,134 +2176,54 @@ func (m *model) navigateRemoteDir(fileName string) {
 			}
 		}
 		m.remotePath = newPath
+		m.remoteMarked.clear()
 		m.loadRemoteFiles(m.remotePath)
 	}
 }
 
-func (m *model) performCopy() {
-	var srcFile, dstFile string
-	var isLocalToRemote bool
-
-	if m.focusPane == "local" {
-		// Copy from local to remote
-		sel := m.localFileList.SelectedItem()
-		if sel == nil {
-			m.message = "No file selected"
-			return
-		}
-		fileName := sel.FilterValue()
-		if strings.HasSuffix(fileName, "/") {
-			m.message = "Cannot copy directories"
-			return
-		}
-		srcFile = filepath.Join(m.localPath, fileName)
-		dstFile = filepath.Join(m.remotePath, fileName)
-		isLocalToRemote = true
-	} else {
-		// Copy from remote to local
-		sel := m.remoteFileList.SelectedItem()
-		if sel == nil {
-			m.message = "No file selected"
-			return
-		}
-		fileName := sel.FilterValue()
-		if strings.HasSuffix(fileName, "/") {
-			m.message = "Cannot copy directories"
-			return
-		}
-		srcFile = filepath.Join(m.remotePath, fileName)
-		dstFile = filepath.Join(m.localPath, fileName)
-		isLocalToRemote = false
-	}
-
-	m.isTransferring = true
-	m.transferMessage = fmt.Sprintf("Copying %s...", filepath.Base(srcFile))
-
-	if isLocalToRemote {
-		m.copyLocalToRemote(srcFile, dstFile)
-	} else {
-		m.copyRemoteToLocal(srcFile, dstFile)
-	}
-
-	m.isTransferring = false
-	m.transferProgress = 0
-	m.loadLocalFiles(m.localPath)
-	m.loadRemoteFiles(m.remotePath)
-}
-
-func (m *model) copyLocalToRemote(localPath, remotePath string) {
-	if m.sftpManager == nil {
+// performCopy queues the marked entries of the focused pane, or the
+// selected one, for transfer to the other pane. A move also deletes each
+// source once its copy has completed.
+func (m *model) performCopy(move bool) tea.Cmd {
+	if m.transferQueue == nil {
 		m.message = "Error: SFTP connection lost"
-		return
+		return nil
 	}
 
-	if err := m.sftpManager.UploadFile(localPath, remotePath); err != nil {
-		m.message = fmt.Sprintf("Error uploading: %v", err)
-	} else {
-		m.message = fmt.Sprintf("Uploaded %s", filepath.Base(localPath))
-		m.transferProgress = 100
-	}
-}
-
-func (m *model) copyRemoteToLocal(remotePath, localPath string) {
-	if m.sftpManager == nil {
-		m.message = "Error: SFTP connection lost"
-		return
-	}
-
-	if err := m.sftpManager.DownloadFile(remotePath, localPath); err != nil {
-		m.message = fmt.Sprintf("Error downloading: %v", err)
-	} else {
-		m.message = fmt.Sprintf("Downloaded %s", filepath.Base(remotePath))
-		m.transferProgress = 100
-	}
-}
-
-func (m *model) deleteLocalFile() {
-	sel := m.localFileList.SelectedItem()
-	if sel == nil {
+	entries := m.selectedEntries()
+	if len(entries) == 0 {
 		m.message = "No file selected"
-		return
-	}
-	fileName := sel.FilterValue()
-	if strings.HasSuffix(fileName, "/") {
-		m.message = "Cannot delete directories"
-		return
+		return nil
 	}
 
-	filePath := filepath.Join(m.localPath, fileName)
-	if err := os.Remove(filePath); err != nil {
-		m.message = fmt.Sprintf("Error deleting: %v", err)
-	} else {
-		m.message = fmt.Sprintf("Deleted %s", fileName)
-		m.loadLocalFiles(m.localPath)
+	isLocalToRemote := m.focusPane == "local"
+	srcFS, srcDir := fileSystem(m.sftpManager), m.remotePath
+	dstFS, dstDir := m.leftFS(), m.localPath
+	if isLocalToRemote {
+		srcFS, srcDir, dstFS, dstDir = dstFS, dstDir, srcFS, srcDir
 	}
-}
 
-func (m *model) deleteRemoteFile() {
-	sel := m.remoteFileList.SelectedItem()
-	if sel == nil {
-		m.message = "No file selected"
-		return
-	}
-	fileName := sel.FilterValue()
-	if strings.HasSuffix(fileName, "/") {
-		m.message = "Cannot delete directories"
-		return
+	for _, e := range entries {
+		// Symlinked directories are copied as trees unless links are kept
+		isDir := e.IsDir() || (e.LinkDir && !m.config.CopyLinks)
+		srcFile := srcFS.Join(srcDir, e.Name)
+		dstFile := dstFS.Join(dstDir, e.Name)
+		m.transferQueue.Enqueue(isLocalToRemote, isDir, move, srcFile, dstFile)
 	}
 
-	if m.sftpManager == nil {
-		m.message = "Error: SFTP connection lost"
-		return
-	}
+	marks, _ := m.paneMarks()
+	marks.clear()
 
-	filePath := filepath.Join(m.remotePath, fileName)
-	if err := m.sftpManager.DeleteFile(filePath); err != nil {
-		m.message = fmt.Sprintf("Error deleting: %v", err)
+	verb := "Queued"
+	if move {
+		verb = "Queued move of"
+	}
+	if len(entries) == 1 {
+		m.message = fmt.Sprintf("%s %s", verb, strings.TrimSuffix(entries[0].Name, "/"))
 	} else {
-		m.message = fmt.Sprintf("Deleted %s", fileName)
-		m.loadRemoteFiles(m.remotePath)
+		m.message = fmt.Sprintf("%s %d items", verb, len(entries))
 	}
+	return nil
 }
 
 func main() {
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// markSet holds the names of the marked entries of a file pane, with the
+// trailing slash of directories as shown in the list. The pane's delegate
+// shares the map, so marks are changed in place and never replaced.
+type markSet map[string]bool
+
+func (s markSet) clear() {
+	for name := range s {
+		delete(s, name)
+	}
+}
+
+// paneMarks returns the marks and listing of the focused pane
+func (m *model) paneMarks() (markSet, *list.Model) {
+	if m.focusPane == "remote" {
+		return m.remoteMarked, &m.remoteFileList
+	}
+	return m.localMarked, &m.localFileList
+}
+
+// toggleMark marks or unmarks the selected entry and moves to the next one
+func (m *model) toggleMark() {
+	marks, l := m.paneMarks()
+	sel := l.SelectedItem()
+	if sel == nil || sel.FilterValue() == "../" {
+		return
+	}
+	name := sel.FilterValue()
+	if marks[name] {
+		delete(marks, name)
+	} else {
+		marks[name] = true
+	}
+	l.CursorDown()
+}
+
+// invertMarks marks every unmarked entry of the focused pane and unmarks
+// the marked ones.
+func (m *model) invertMarks() {
+	marks, l := m.paneMarks()
+	for _, item := range l.Items() {
+		name := item.FilterValue()
+		if name == "../" {
+			continue
+		}
+		if marks[name] {
+			delete(marks, name)
+		} else {
+			marks[name] = true
+		}
+	}
+}
+
+// markMatching marks the entries whose name matches a shell pattern such
+// as *.log and returns how many matched.
+func (m *model) markMatching(pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	marks, l := m.paneMarks()
+	matched := 0
+	for _, item := range l.Items() {
+		name := item.FilterValue()
+		if name == "../" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, strings.TrimSuffix(name, "/")); ok {
+			marks[name] = true
+			matched++
+		}
+	}
+	return matched, nil
+}
+
+// pruneMarks drops marks of entries that are no longer listed
+func pruneMarks(marks markSet, items []list.Item) {
+	listed := make(map[string]bool, len(items))
+	for _, item := range items {
+		listed[item.FilterValue()] = true
+	}
+	for name := range marks {
+		if !listed[name] {
+			delete(marks, name)
+		}
+	}
+}
+
+// selectedEntries returns the entries an operation on the focused pane
+// applies to: the marked ones in list order, or else the selected one.
+// The parent entry "../" is never included.
+func (m model) selectedEntries() []fileEntry {
+	marks, l := m.paneMarks()
+	var entries []fileEntry
+	if len(marks) > 0 {
+		for _, item := range l.Items() {
+			if marks[item.FilterValue()] {
+				entries = append(entries, item.(fileEntry))
+			}
+		}
+		return entries
+	}
+	if sel := l.SelectedItem(); sel != nil && sel.FilterValue() != "../" {
+		entries = append(entries, sel.(fileEntry))
+	}
+	return entries
+}
+
+// selectedNames returns the names of selectedEntries; directories keep
+// their trailing slash.
+func (m model) selectedNames() []string {
+	var names []string
+	for _, e := range m.selectedEntries() {
+		names = append(names, e.Name)
+	}
+	return names
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+func TestMarkMatching(t *testing.T) {
+	names := []string{"../", "logs/", "app.log", "app.log.1", "error.log", "notes.txt", "[draft]"}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{name: "extension", pattern: "*.log", want: []string{"app.log", "error.log"}},
+		{name: "prefix", pattern: "app.*", want: []string{"app.log", "app.log.1"}},
+		{name: "directory", pattern: "logs", want: []string{"logs/"}},
+		{name: "everything", pattern: "*", want: []string{"[draft]", "app.log", "app.log.1", "error.log", "logs/", "notes.txt"}},
+		{name: "parent", pattern: "..", want: nil},
+		{name: "escaped bracket", pattern: `\[draft\]`, want: []string{"[draft]"}},
+		{name: "no match", pattern: "*.go", want: nil},
+		{name: "invalid pattern", pattern: "[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := make([]list.Item, len(names))
+			for i, name := range names {
+				items[i] = fileEntry{Name: name}
+			}
+			m := model{focusPane: "local", localMarked: markSet{}}
+			m.localFileList = list.New(items, list.NewDefaultDelegate(), 0, 0)
+
+			n, err := m.markMatching(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("markMatching(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+			var got []string
+			for name := range m.localMarked {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("markMatching(%q) marked %q, want %q", tt.pattern, got, tt.want)
+			}
+			if n != len(tt.want) {
+				t.Errorf("markMatching(%q) = %d, want %d", tt.pattern, n, len(tt.want))
+			}
+		})
+	}
+}
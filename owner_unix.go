@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var (
+	userNamesMu sync.Mutex
+	userNames   = map[uint32]string{}
+)
+
+// localOwner returns the name of the user owning a local file
+func localOwner(info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+
+	userNamesMu.Lock()
+	defer userNamesMu.Unlock()
+	if name, ok := userNames[stat.Uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(stat.Uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	userNames[stat.Uid] = name
+	return name
+}
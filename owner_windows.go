@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// localOwner returns "" on Windows, where files have no Unix owner
+func localOwner(info os.FileInfo) string {
+	return ""
+}
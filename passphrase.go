@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyPassphraseInput is the index of the key passphrase in the server form
+const keyPassphraseInput = 10
+
+const unencryptedPassphraseWarning = "Passphrase stored unencrypted (set a master passphrase from the [m]enu)"
+
+// keyPrompt is the state of the passphrase prompt for an encrypted key
+type keyPrompt struct {
+	Key      string // private key being unlocked
+	Wrong    bool   // the last passphrase tried was wrong
+	Remember bool   // store the passphrase in the config
+	input    textinput.Model
+}
+
+// connectServer resolves a server for connecting and supplies the
+// passphrase entered for its key earlier in this session, if any.
+func (m model) connectServer(server Server) Server {
+	server = m.config.resolveServer(server)
+	if pass, ok := m.keyPassphrases[normalizePemKey(server.PemKey)]; ok && server.PemKey != "" {
+		server.KeyPassphrase = pass
+	}
+	return server
+}
+
+// handlePassphraseError shows the passphrase prompt if the server's key is
+// encrypted and no passphrase, or a wrong one, was given. It reports
+// whether it did so.
+func (m *model) handlePassphraseError(err error, action string, server Server) bool {
+	var missing *ssh.PassphraseMissingError
+	wrong := errors.Is(err, x509.IncorrectPasswordError)
+	if !errors.As(err, &missing) && !wrong {
+		return false
+	}
+	m.keyPrompt = &keyPrompt{
+		Key:   normalizePemKey(server.PemKey),
+		Wrong: wrong,
+		input: newPassphraseInput("Passphrase: ", "passphrase of the private key"),
+	}
+	m.keyPrompt.input.Focus()
+	m.pendingAction = action
+	m.pendingServer = server
+	m.state = passphraseView
+	m.message = ""
+	return true
+}
+
+// rememberPassphrase stores pass with whatever supplies the key of server:
+// the server itself, its identity or the group it inherits the key from.
+func (m *model) rememberPassphrase(server Server, key, pass string) {
+	for i, s := range m.config.Servers {
+		if s.ID != server.ID {
+			continue
+		}
+		if s.PemKey != "" && normalizePemKey(s.PemKey) == key {
+			m.config.Servers[i].KeyPassphrase = pass
+			return
+		}
+		if id := m.config.identity(s.IdentityID); id != nil && normalizePemKey(id.PrivateKey) == key {
+			id.Passphrase = pass
+			return
+		}
+		for _, g := range m.config.groupChain(s.GroupID) {
+			if g.PemKey != "" && normalizePemKey(g.PemKey) == key {
+				g.KeyPassphrase = pass
+				return
+			}
+		}
+	}
+}
+
+func (m model) updatePassphraseView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	p := m.keyPrompt
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+
+	case "esc":
+		m.keyPrompt = nil
+		m.state = listView
+		m.message = fmt.Sprintf("Connection to %s cancelled", m.pendingServer.Name)
+		return m, nil
+
+	case "tab":
+		p.Remember = !p.Remember
+		return m, nil
+
+	case "enter":
+		pass := p.input.Value()
+		if pass == "" {
+			return m, nil
+		}
+		if _, err := parseSigner(p.Key, pass); err != nil {
+			p.Wrong = true
+			p.input.SetValue("")
+			return m, nil
+		}
+		if m.keyPassphrases == nil {
+			m.keyPassphrases = map[string]string{}
+		}
+		m.keyPassphrases[p.Key] = pass
+		m.keyPrompt = nil
+		m.state = listView
+
+		if p.Remember {
+			m.rememberPassphrase(m.pendingServer, p.Key, pass)
+			if err := m.saveConfig(); err != nil {
+				m.message = fmt.Sprintf("Error saving passphrase: %v", err)
+				return m, nil
+			}
+		}
+		next, cmd := m.retryPendingAction()
+		if m, ok := next.(model); ok && p.Remember && m.vault == nil {
+			// Shown in front of the connection status
+			m.message = strings.TrimSuffix(unencryptedPassphraseWarning+" • "+m.message, " • ")
+			return m, cmd
+		}
+		return next, cmd
+	}
+
+	var cmd tea.Cmd
+	p.input, cmd = p.input.Update(msg)
+	return m, cmd
+}
+
+func (m model) viewPassphrase() string {
+	p := m.keyPrompt
+	s := m.pendingServer
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Encrypted Private Key") + "\n\n")
+	b.WriteString(helpStyle.Render(fmt.Sprintf("The key for %s@%s is protected by a passphrase.", s.Username, s.Host)) + "\n\n")
+	b.WriteString("  " + p.input.View() + "\n\n")
+
+	remember := "[ ]"
+	if p.Remember {
+		remember = "[x]"
+	}
+	b.WriteString(fmt.Sprintf("  %s Remember for this key\n", remember))
+	if p.Remember && m.vault == nil {
+		b.WriteString("      " + helpStyle.Render("stored in the config without encryption") + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("[enter] connect • [tab] toggle remember • [esc] cancel"))
+
+	if p.Wrong {
+		b.WriteString("\n\n" + errorStyle.Render("Error: wrong passphrase, try again"))
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestPassphrasePrompt(t *testing.T) {
+	_, encrypted, _ := testKeys(t, "secret")
+
+	tests := []struct {
+		name        string
+		config      Config
+		remember    bool
+		vault       bool
+		wantStored  func(c *Config) string // where a remembered passphrase goes
+		wantWarning bool
+	}{
+		{
+			name:   "session only",
+			config: Config{Servers: []Server{{ID: 1, Name: "web", PemKey: encrypted}}},
+		},
+		{
+			name:        "remembered on the server",
+			config:      Config{Servers: []Server{{ID: 1, Name: "web", PemKey: encrypted}}},
+			remember:    true,
+			wantStored:  func(c *Config) string { return c.Servers[0].KeyPassphrase },
+			wantWarning: true,
+		},
+		{
+			name: "remembered on the identity",
+			config: Config{
+				Servers:    []Server{{ID: 1, Name: "web", IdentityID: 2}},
+				Identities: []Identity{{ID: 2, Name: "ci", PrivateKey: encrypted}},
+			},
+			remember:    true,
+			wantStored:  func(c *Config) string { return c.Identities[0].Passphrase },
+			wantWarning: true,
+		},
+		{
+			name: "remembered on the group",
+			config: Config{
+				Servers: []Server{{ID: 1, Name: "web", GroupID: 4}},
+				Groups:  []Group{{ID: 3, Name: "prod", PemKey: encrypted}, {ID: 4, Name: "web", ParentID: 3}},
+			},
+			remember:    true,
+			wantStored:  func(c *Config) string { return c.Groups[0].KeyPassphrase },
+			wantWarning: true,
+		},
+		{
+			name:       "remembered in a vault",
+			config:     Config{Servers: []Server{{ID: 1, Name: "web", PemKey: encrypted}}},
+			remember:   true,
+			vault:      true,
+			wantStored: func(c *Config) string { return c.Servers[0].KeyPassphrase },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			m := model{config: &config, configPath: filepath.Join(t.TempDir(), "config.json")}
+			if tt.vault {
+				v, err := newVault("master")
+				if err != nil {
+					t.Fatal(err)
+				}
+				m.vault = v
+			}
+
+			server := m.connectServer(config.Servers[0])
+			_, err := parseSigner(server.PemKey, server.KeyPassphrase)
+			if !m.handlePassphraseError(err, "ssh", server) {
+				t.Fatalf("no prompt for %v", err)
+			}
+			if m.state != passphraseView || m.keyPrompt.Wrong {
+				t.Fatalf("state %v, wrong %v; want the prompt for a missing passphrase", m.state, m.keyPrompt.Wrong)
+			}
+			m.keyPrompt.Remember = tt.remember
+
+			// A wrong passphrase keeps the prompt open
+			m.keyPrompt.input.SetValue("wrong")
+			next, cmd := m.updatePassphraseView(tea.KeyMsg{Type: tea.KeyEnter})
+			m = next.(model)
+			if m.state != passphraseView || !m.keyPrompt.Wrong || cmd != nil {
+				t.Fatal("wrong passphrase accepted")
+			}
+
+			m.keyPrompt.input.SetValue("secret")
+			next, cmd = m.updatePassphraseView(tea.KeyMsg{Type: tea.KeyEnter})
+			m = next.(model)
+			if m.keyPrompt != nil || !m.connecting || cmd == nil {
+				t.Fatalf("connection not retried: %s", m.message)
+			}
+			if got := m.connectServer(config.Servers[0]).KeyPassphrase; got != "secret" {
+				t.Errorf("passphrase for the next connection = %q, want %q", got, "secret")
+			}
+			if tt.wantStored != nil {
+				if got := tt.wantStored(&config); got != "secret" {
+					t.Errorf("stored passphrase = %q, want %q", got, "secret")
+				}
+			} else if config.Servers[0].KeyPassphrase != "" {
+				t.Error("passphrase stored without remember")
+			}
+			if warned := strings.HasPrefix(m.message, unencryptedPassphraseWarning); warned != tt.wantWarning {
+				t.Errorf("message = %q, want warning %v", m.message, tt.wantWarning)
+			}
+			if !strings.Contains(m.message, "Connecting to web") {
+				t.Errorf("message = %q, want the connection status", m.message)
+			}
+		})
+	}
+}
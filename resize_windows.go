@@ -0,0 +1,35 @@
+//go:build windows
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/term"
+)
+
+// watchWindowSize polls the console size on fd and calls onResize when it
+// changes, until stop is called. Windows has no SIGWINCH.
+func watchWindowSize(fd int, onResize func(width, height int)) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		lastWidth, lastHeight, _ := term.GetSize(fd)
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				width, height, err := term.GetSize(fd)
+				if err == nil && (width != lastWidth || height != lastHeight) {
+					lastWidth, lastHeight = width, height
+					onResize(width, height)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
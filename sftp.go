@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -15,29 +21,15 @@ import (
 type SFTPManager struct {
 	client *sftp.Client
 	conn   *ssh.Client
-}
-
-// ConnectSFTP creates a new SFTP connection
-func ConnectSFTP(server *Server) (*SFTPManager, error) {
-	// Setup SSH config
-	config := &ssh.ClientConfig{
-		User:            server.Username,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // Warning: insecure for production
-	}
 
-	// Use PEM key if available
-	if server.PemKey != "" {
-		normalized := normalizePemKey(server.PemKey)
-		signer, err := ssh.ParsePrivateKey([]byte(normalized))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse PEM key: %v", err)
-		}
-		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
-	} else if server.Password != "" {
-		// Use password authentication
-		config.Auth = []ssh.AuthMethod{ssh.Password(server.Password)}
-	}
+	ownersOnce sync.Once
+	owners     map[uint32]string // user names by UID from the server's /etc/passwd
+}
 
+// ConnectSFTP creates a new SFTP connection. The server's host key is
+// checked against hostKeys according to the server's policy. Questions of
+// keyboard-interactive auth go to challenge, if set.
+func ConnectSFTP(server *Server, hostKeys *knownHostsStore, challenge ssh.KeyboardInteractiveChallenge) (*SFTPManager, error) {
 	// Determine SFTP port
 	port := server.SFTPPort
 	if port == 0 {
@@ -48,10 +40,9 @@ func ConnectSFTP(server *Server) (*SFTPManager, error) {
 	}
 
 	// Connect to SSH server
-	sshAddr := fmt.Sprintf("%s:%d", server.Host, port)
-	conn, err := ssh.Dial("tcp", sshAddr, config)
+	conn, err := dialSSH(context.Background(), server, port, hostKeys, challenge)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH server: %v", err)
+		return nil, err
 	}
 
 	// Create SFTP client
@@ -133,52 +124,219 @@ func (sm *SFTPManager) ChangeDirectory(path string) error {
 	return err
 }
 
-// UploadFile uploads a local file to the remote server
-func (sm *SFTPManager) UploadFile(localPath, remotePath string) error {
-	// Open local file
-	localFile, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open local file: %v", err)
+// UploadFile uploads a local file to the remote server. An interrupted
+// upload leaves remotePath+".part" behind and the next upload of the same
+// file resumes from it. Uploaded bytes are also written to progress if it
+// is not nil, and the upload stops when ctx is done.
+func (sm *SFTPManager) UploadFile(ctx context.Context, localPath, remotePath string, opts transferOptions, progress io.Writer) error {
+	return copyFile(ctx, localFS{}, localPath, sm, remotePath, opts, progress)
+}
+
+// DownloadFile downloads a file from the remote server. An interrupted
+// download leaves localPath+".part" behind and the next download of the
+// same file resumes from it. Downloaded bytes are also written to progress
+// if it is not nil, and the download stops when ctx is done.
+func (sm *SFTPManager) DownloadFile(ctx context.Context, remotePath, localPath string, opts transferOptions, progress io.Writer) error {
+	return copyFile(ctx, sm, remotePath, localFS{}, localPath, opts, progress)
+}
+
+// Stat returns file info, following symlinks
+func (sm *SFTPManager) Stat(path string) (os.FileInfo, error) {
+	return sm.client.Stat(path)
+}
+
+// Lstat returns file info without following symlinks
+func (sm *SFTPManager) Lstat(path string) (os.FileInfo, error) {
+	return sm.client.Lstat(path)
+}
+
+// Open opens a remote file for reading
+func (sm *SFTPManager) Open(path string) (fsFile, error) {
+	return sm.client.Open(path)
+}
+
+// OpenFile opens a remote file with the given os.O_* flags
+func (sm *SFTPManager) OpenFile(path string, flag int) (fsFile, error) {
+	return sm.client.OpenFile(path, flag)
+}
+
+// MkdirAll creates a directory and any missing parents
+func (sm *SFTPManager) MkdirAll(path string) error {
+	return sm.client.MkdirAll(path)
+}
+
+// Chmod changes the permissions of a remote file
+func (sm *SFTPManager) Chmod(path string, mode os.FileMode) error {
+	return sm.client.Chmod(path, mode)
+}
+
+// Chtimes changes the access and modification times of a remote file
+func (sm *SFTPManager) Chtimes(path string, atime, mtime time.Time) error {
+	return sm.client.Chtimes(path, atime, mtime)
+}
+
+// Chown changes the owner and group of a remote file. SFTP always sets
+// both, so an ID of -1 is filled in from the file's current owner.
+func (sm *SFTPManager) Chown(path string, uid, gid int) error {
+	if uid < 0 || gid < 0 {
+		info, err := sm.client.Stat(path)
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*sftp.FileStat)
+		if !ok {
+			return fmt.Errorf("server did not report the owner of %s", path)
+		}
+		if uid < 0 {
+			uid = int(stat.UID)
+		}
+		if gid < 0 {
+			gid = int(stat.GID)
+		}
 	}
-	defer localFile.Close()
+	return sm.client.Chown(path, uid, gid)
+}
 
-	// Create remote file
-	remoteFile, err := sm.client.Create(remotePath)
-	if err != nil {
-		return fmt.Errorf("failed to create remote file: %v", err)
+// Owner returns the name of the user owning a remote file, or its UID if
+// the server's /etc/passwd does not name it.
+func (sm *SFTPManager) Owner(info os.FileInfo) string {
+	stat, ok := info.Sys().(*sftp.FileStat)
+	if !ok {
+		return ""
 	}
-	defer remoteFile.Close()
+	sm.ownersOnce.Do(func() {
+		sm.owners = map[uint32]string{}
+		f, err := sm.client.Open("/etc/passwd")
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) < 3 {
+				continue
+			}
+			if uid, err := strconv.ParseUint(fields[2], 10, 32); err == nil {
+				sm.owners[uint32(uid)] = fields[0]
+			}
+		}
+	})
+	if name, ok := sm.owners[stat.UID]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(stat.UID), 10)
+}
 
-	// Copy content
-	if _, err := io.Copy(remoteFile, localFile); err != nil {
-		return fmt.Errorf("failed to upload file: %v", err)
+// Rename renames a remote file, replacing newPath if it exists. Servers
+// without the posix-rename extension get a remove followed by a rename.
+func (sm *SFTPManager) Rename(oldPath, newPath string) error {
+	if _, ok := sm.client.HasExtension("posix-rename@openssh.com"); ok {
+		return sm.client.PosixRename(oldPath, newPath)
 	}
+	if err := sm.client.Remove(newPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return sm.client.Rename(oldPath, newPath)
+}
 
-	return nil
+// Readlink returns the target of a remote symlink
+func (sm *SFTPManager) Readlink(path string) (string, error) {
+	return sm.client.ReadLink(path)
+}
+
+// Symlink creates a remote symlink at path pointing to target
+func (sm *SFTPManager) Symlink(target, path string) error {
+	return sm.client.Symlink(target, path)
 }
 
-// DownloadFile downloads a file from the remote server
-func (sm *SFTPManager) DownloadFile(remotePath, localPath string) error {
-	// Open remote file
-	remoteFile, err := sm.client.Open(remotePath)
+// Remove deletes a remote file or empty directory
+func (sm *SFTPManager) Remove(path string) error {
+	return sm.client.Remove(path)
+}
+
+// RemoveAll deletes path and everything below it. Files are removed while
+// walking the tree, then the directories from the deepest up.
+func (sm *SFTPManager) RemoveAll(path string) error {
+	var dirs []string
+	err := sm.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, p)
+			return nil
+		}
+		return sm.client.Remove(p)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open remote file: %v", err)
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := sm.client.RemoveDirectory(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk calls fn for root and every entry below it, without following
+// symlinks. fn may return filepath.SkipDir to skip a directory.
+func (sm *SFTPManager) Walk(root string, fn filepath.WalkFunc) error {
+	walker := sm.client.Walk(root)
+	for walker.Step() {
+		err := fn(walker.Path(), walker.Stat(), walker.Err())
+		if err == filepath.SkipDir {
+			if info := walker.Stat(); info != nil && info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
 	}
-	defer remoteFile.Close()
+	return nil
+}
 
-	// Create local file
-	localFile, err := os.Create(localPath)
+// Checksum returns the hex SHA-256 of a remote file computed on the server
+// with sha256sum, which avoids reading the file back over the connection.
+func (sm *SFTPManager) Checksum(path string) (string, error) {
+	session, err := sm.conn.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create local file: %v", err)
+		return "", err
 	}
-	defer localFile.Close()
+	defer session.Close()
 
-	// Copy content
-	if _, err := io.Copy(localFile, remoteFile); err != nil {
-		return fmt.Errorf("failed to download file: %v", err)
+	out, err := session.Output("sha256sum -- " + shellQuote(path))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || len(fields[0]) != 64 {
+		return "", fmt.Errorf("unexpected sha256sum output: %q", out)
 	}
+	return strings.ToLower(fields[0]), nil
+}
 
-	return nil
+// shellQuote quotes s as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Join joins remote path elements with forward slashes
+func (sm *SFTPManager) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// UploadDir recursively uploads a local directory to the remote server
+func (sm *SFTPManager) UploadDir(ctx context.Context, localDir, remoteDir string, opts transferOptions, progress io.Writer) (transferStats, error) {
+	return copyTree(ctx, localFS{}, localDir, sm, remoteDir, opts, progress)
+}
+
+// DownloadDir recursively downloads a remote directory
+func (sm *SFTPManager) DownloadDir(ctx context.Context, remoteDir, localDir string, opts transferOptions, progress io.Writer) (transferStats, error) {
+	return copyTree(ctx, sm, remoteDir, localFS{}, localDir, opts, progress)
 }
 
 // FormatFileList returns formatted file list for display
@@ -196,6 +354,20 @@ func FormatFileList(files []os.FileInfo) []string {
 	return formatted
 }
 
+// FormatSize returns a human-readable byte count
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // TrimPathSlash removes trailing slash from path
 func TrimPathSlash(path string) string {
 	return strings.TrimSuffix(path, "/")
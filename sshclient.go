@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// parseSigner parses a PEM or OpenSSH private key, decrypting it with
+// passphrase if one is given.
+func parseSigner(pemKey, passphrase string) (ssh.Signer, error) {
+	normalized := []byte(normalizePemKey(pemKey))
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(normalized, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PEM key: %w", err)
+		}
+		return signer, nil
+	}
+	signer, err := ssh.ParsePrivateKey(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PEM key: %w", err)
+	}
+	return signer, nil
+}
+
+// sshAuthMethods builds the auth methods for a server's stored credentials.
+// The same methods are used for the terminal and for SFTP.
+func sshAuthMethods(server *Server) ([]ssh.AuthMethod, error) {
+	// Use PEM key if available
+	if server.PemKey != "" {
+		signer, err := parseSigner(server.PemKey, server.KeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	// Use password authentication
+	if server.Password != "" {
+		return []ssh.AuthMethod{ssh.Password(server.Password)}, nil
+	}
+
+	return nil, nil
+}
+
+// dialSSH connects and authenticates to the server on the given port. The
+// server's host key is checked against hostKeys according to its policy.
+// With a challenge, keyboard-interactive auth is offered too, alone or
+// after the stored credentials, e.g. for a one-time code. Connecting and
+// the handshake stop with ctx's error when ctx is done.
+func dialSSH(ctx context.Context, server *Server, port int, hostKeys *knownHostsStore, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Client, error) {
+	hostKeyCallback, err := hostKeys.callback(server.HostKeyPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := sshAuthMethods(server)
+	if err != nil {
+		return nil, err
+	}
+	if len(auth) == 0 {
+		// No stored credentials: use the keys loaded in ssh-agent
+		conn, err := dialAgent()
+		switch {
+		case err == nil:
+			defer conn.Close()
+			auth = append(auth, agentAuth(agent.NewClient(conn), server.AgentKey))
+		case challenge == nil:
+			return nil, fmt.Errorf("no password or key stored for %s, and %v", server.Name, err)
+		}
+	}
+	if challenge != nil {
+		auth = append(auth, ssh.KeyboardInteractive(challenge))
+	}
+
+	addr := net.JoinHostPort(server.Host, strconv.Itoa(port))
+	config := &ssh.ClientConfig{
+		User:              server.Username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeys.knownAlgorithms(addr),
+	}
+
+	var dialer net.Dialer
+	tcp, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+	}
+	// Closing the connection ends a handshake that hangs
+	stop := context.AfterFunc(ctx, func() { tcp.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(tcp, addr, config)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, fmt.Errorf("failed to connect to SSH server: %w", ctx.Err())
+	}
+	if err != nil {
+		tcp.Close()
+		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -9,8 +9,10 @@ import (
 
 // loadConfig reads the config from the given path. If the file does not exist
 // or cannot be read, it returns a default empty config. The config directory
-// will be created with 0700 permissions if missing.
-func loadConfig(path string) *Config {
+// will be created with 0700 permissions if missing. If the file holds an
+// encrypted vault, the config is returned empty along with the vault so the
+// caller can unlock it with the master passphrase.
+func loadConfig(path string) (*Config, *vaultFile) {
     config := &Config{
         Servers: []Server{},
         NextID:  1,
@@ -18,31 +20,48 @@ func loadConfig(path string) *Config {
 
     dir := filepath.Dir(path)
     if err := os.MkdirAll(dir, 0700); err != nil {
-        return config
+        return config, nil
     }
 
     data, err := ioutil.ReadFile(path)
     if err != nil {
-        return config
+        return config, nil
+    }
+
+    if vf := parseVaultFile(data); vf != nil {
+        return config, vf
     }
 
     if err := json.Unmarshal(data, config); err != nil {
-        return config
+        return config, nil
     }
-    return config
+    return config, nil
 }
 
-// saveConfig writes the current config to disk with 0600 permissions.
+// saveConfig writes the current config to disk with 0600 permissions. When a
+// vault is unlocked the config is re-encrypted on every save. The file is
+// replaced atomically so a failed write never leaves a truncated vault.
 func (m *model) saveConfig() error {
     data, err := json.MarshalIndent(m.config, "", "  ")
     if err != nil {
         return err
     }
 
+    if m.vault != nil {
+        data, err = m.vault.seal(data)
+        if err != nil {
+            return err
+        }
+    }
+
     dir := filepath.Dir(m.configPath)
     if err := os.MkdirAll(dir, 0700); err != nil {
         return err
     }
 
-    return ioutil.WriteFile(m.configPath, data, 0600)
+    tmpPath := m.configPath + ".tmp"
+    if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmpPath, m.configPath)
 }
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// syncMode chooses which way a sync copies
+type syncMode int
+
+const (
+	syncToRight  syncMode = iota // make the right pane match the left
+	syncToLeft                   // make the left pane match the right
+	syncBothWays                 // copy missing and newer files both ways
+)
+
+type syncKind int
+
+const (
+	syncCopyRight syncKind = iota
+	syncCopyLeft
+	syncDeleteRight
+	syncDeleteLeft
+)
+
+// syncAction is one step of a sync plan. Path is relative to both pane
+// directories and uses forward slashes.
+type syncAction struct {
+	Kind   syncKind
+	Path   string
+	IsDir  bool
+	Size   int64
+	Reason string
+}
+
+// syncPlan is the previewed outcome of comparing the two panes
+type syncPlan struct {
+	Mode      syncMode
+	Deletes   bool // in one-way modes, delete what the source does not have
+	Checksum  bool
+	Actions   []syncAction
+	Conflicts []string // paths that cannot be synced automatically
+	Skipped   int      // unreadable directories, symlinks and special files
+}
+
+// syncTree is one side of a comparison: the entries below a directory by
+// relative path, and the total file size below each subdirectory.
+type syncTree struct {
+	entries map[string]os.FileInfo
+	sizes   map[string]int64
+	skipped int
+}
+
+// syncComparison holds both scanned trees. With checksums, sameContent
+// records which same-sized files on both sides have equal SHA-256 sums.
+type syncComparison struct {
+	left, right syncTree
+	checksum    bool
+	sameContent map[string]bool
+}
+
+// syncComparedMsg delivers a finished comparison to Update
+type syncComparedMsg struct {
+	seq int
+	cmp *syncComparison
+	err error
+}
+
+// syncDeletedMsg reports the deletes of a sync, after which its copies are
+// queued
+type syncDeletedMsg struct {
+	plan                *syncPlan
+	leftRoot, rightRoot string
+	deleted             int
+	failed              string // the entry that could not be deleted
+	err                 error
+}
+
+// relPath returns p relative to root with forward slashes
+func relPath(root, p string) string {
+	rel := strings.TrimPrefix(p, root)
+	return strings.TrimLeft(filepath.ToSlash(rel), "/")
+}
+
+// fsPath joins a relative slash-separated path onto root
+func fsPath(fsys fileSystem, root, rel string) string {
+	return fsys.Join(append([]string{root}, strings.Split(rel, "/")...)...)
+}
+
+// scanTree walks root on fsys. Symlinks and special files are not synced,
+// and directories that cannot be read are skipped rather than failing the
+// whole comparison.
+func scanTree(fsys fileSystem, root string) (syncTree, error) {
+	tree := syncTree{entries: map[string]os.FileInfo{}, sizes: map[string]int64{}}
+	err := fsys.Walk(root, func(p string, info os.FileInfo, err error) error {
+		rel := relPath(root, p)
+		if err != nil {
+			if rel == "" {
+				return err
+			}
+			tree.skipped++
+			return nil
+		}
+		if rel == "" {
+			return nil
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			tree.skipped++
+			return nil
+		}
+		if !info.IsDir() && isPartial(info.Name()) {
+			// Leftovers of interrupted copies
+			return nil
+		}
+		tree.entries[rel] = info
+		if !info.IsDir() {
+			for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+				tree.sizes[dir] += info.Size()
+			}
+		}
+		return nil
+	})
+	return tree, err
+}
+
+// size returns the bytes a copy of rel would transfer
+func (t syncTree) size(rel string) int64 {
+	if info := t.entries[rel]; info != nil && !info.IsDir() {
+		return info.Size()
+	}
+	return t.sizes[rel]
+}
+
+// compareTrees scans both directories and, with checksum, hashes the files
+// that exist on both sides with the same size.
+func compareTrees(left fileSystem, leftRoot string, right fileSystem, rightRoot string, checksum bool) (*syncComparison, error) {
+	cmp := &syncComparison{checksum: checksum, sameContent: map[string]bool{}}
+	var err error
+	if cmp.left, err = scanTree(left, leftRoot); err != nil {
+		return nil, err
+	}
+	if cmp.right, err = scanTree(right, rightRoot); err != nil {
+		return nil, err
+	}
+	if !checksum {
+		return cmp, nil
+	}
+
+	for rel, l := range cmp.left.entries {
+		r := cmp.right.entries[rel]
+		if r == nil || l.IsDir() || r.IsDir() || l.Size() != r.Size() {
+			continue
+		}
+		leftSum, err := fileChecksum(left, fsPath(left, leftRoot, rel))
+		if err != nil {
+			return nil, fmt.Errorf("failed to checksum %s: %v", rel, err)
+		}
+		rightSum, err := fileChecksum(right, fsPath(right, rightRoot, rel))
+		if err != nil {
+			return nil, fmt.Errorf("failed to checksum %s: %v", rel, err)
+		}
+		cmp.sameContent[rel] = leftSum == rightSum
+	}
+	return cmp, nil
+}
+
+// differ reports whether two files at rel need syncing: by size and
+// modification time (to the second, as SFTP stores it), or by size and
+// checksum.
+func (c *syncComparison) differ(rel string) bool {
+	l, r := c.left.entries[rel], c.right.entries[rel]
+	if l.Size() != r.Size() {
+		return true
+	}
+	if c.checksum {
+		return !c.sameContent[rel]
+	}
+	return l.ModTime().Unix() != r.ModTime().Unix()
+}
+
+// planSync decides what to copy and delete. Paths are visited in sorted
+// order so a directory copied or deleted as a whole covers its contents.
+func planSync(c *syncComparison, mode syncMode, deletes bool) *syncPlan {
+	plan := &syncPlan{Mode: mode, Deletes: deletes, Checksum: c.checksum, Skipped: c.left.skipped + c.right.skipped}
+
+	var paths []string
+	for rel := range c.left.entries {
+		paths = append(paths, rel)
+	}
+	for rel := range c.right.entries {
+		if c.left.entries[rel] == nil {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+
+	covered := map[string]bool{}
+	isCovered := func(rel string) bool {
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			if covered[dir] {
+				return true
+			}
+		}
+		return false
+	}
+	add := func(kind syncKind, rel string, info os.FileInfo, reason string) {
+		tree := c.left
+		if kind == syncCopyLeft || kind == syncDeleteRight {
+			tree = c.right
+		}
+		plan.Actions = append(plan.Actions, syncAction{Kind: kind, Path: rel, IsDir: info.IsDir(), Size: tree.size(rel), Reason: reason})
+		if info.IsDir() {
+			covered[rel] = true
+		}
+	}
+
+	for _, rel := range paths {
+		if isCovered(rel) {
+			continue
+		}
+		l, r := c.left.entries[rel], c.right.entries[rel]
+
+		switch {
+		case r == nil:
+			if mode != syncToLeft {
+				add(syncCopyRight, rel, l, "new")
+			} else if deletes {
+				add(syncDeleteLeft, rel, l, "extra")
+			}
+
+		case l == nil:
+			if mode != syncToRight {
+				add(syncCopyLeft, rel, r, "new")
+			} else if deletes {
+				add(syncDeleteRight, rel, r, "extra")
+			}
+
+		case l.IsDir() && r.IsDir():
+			// Compared through their contents
+
+		case l.IsDir() != r.IsDir():
+			// A file on one side and a directory on the other can only be
+			// resolved by deleting the destination first.
+			switch {
+			case mode == syncToRight && deletes:
+				add(syncDeleteRight, rel, r, "replaced")
+				add(syncCopyRight, rel, l, "replaces")
+			case mode == syncToLeft && deletes:
+				add(syncDeleteLeft, rel, l, "replaced")
+				add(syncCopyLeft, rel, r, "replaces")
+			default:
+				plan.Conflicts = append(plan.Conflicts, rel)
+				covered[rel] = true
+			}
+
+		case c.differ(rel):
+			switch {
+			case mode == syncToRight:
+				add(syncCopyRight, rel, l, "changed")
+			case mode == syncToLeft:
+				add(syncCopyLeft, rel, r, "changed")
+			case l.ModTime().Unix() > r.ModTime().Unix():
+				add(syncCopyRight, rel, l, "newer")
+			case r.ModTime().Unix() > l.ModTime().Unix():
+				add(syncCopyLeft, rel, r, "newer")
+			default:
+				plan.Conflicts = append(plan.Conflicts, rel)
+			}
+		}
+	}
+	return plan
+}
+
+// --- Sync view ---
+
+// startSync compares the two pane directories in the background and opens
+// the preview. The focused pane is the source of a one-way sync.
+func (m *model) startSync() tea.Cmd {
+	if m.sftpManager == nil {
+		m.message = "Error: SFTP connection lost"
+		return nil
+	}
+	m.syncMode = syncToRight
+	if m.focusPane == "remote" {
+		m.syncMode = syncToLeft
+	}
+	m.syncDeletes = false
+	m.state = syncView
+	m.message = ""
+	return m.compareForSync()
+}
+
+// compareForSync starts a comparison; results of older ones are ignored
+func (m *model) compareForSync() tea.Cmd {
+	m.syncSeq++
+	m.syncCompare = nil
+	m.syncPlan = nil
+	seq, checksum := m.syncSeq, m.syncChecksum
+	left, leftRoot := m.leftFS(), m.localPath
+	right, rightRoot := m.sftpManager, m.remotePath
+	return func() tea.Msg {
+		cmp, err := compareTrees(left, leftRoot, right, rightRoot, checksum)
+		return syncComparedMsg{seq: seq, cmp: cmp, err: err}
+	}
+}
+
+// replanSync recomputes the plan after an option change
+func (m *model) replanSync() {
+	if m.syncCompare == nil {
+		return
+	}
+	m.syncPlan = planSync(m.syncCompare, m.syncMode, m.syncDeletes)
+	m.resizeSyncView()
+	m.syncViewport.SetContent(m.syncPlanLines())
+	m.syncViewport.GotoTop()
+}
+
+func (m *model) resizeSyncView() {
+	m.syncViewport.Width = m.width - 4
+	m.syncViewport.Height = m.height - 9
+	if m.syncViewport.Height < 3 {
+		m.syncViewport.Height = 3
+	}
+}
+
+func (m model) updateSyncView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.syncCancel != nil {
+		// Deleting: only stopping is possible
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc", "q":
+			m.syncCancel()
+			m.message = "Stopping sync..."
+		}
+		return m, nil
+	}
+
+	switch msg.String() {
+	case "ctrl+c", "q", "esc":
+		m.syncSeq++ // drop a comparison still running
+		m.syncCompare = nil
+		m.syncPlan = nil
+		m.state = sftpView
+		return m, nil
+
+	case "tab":
+		m.syncMode = (m.syncMode + 1) % 3
+		m.replanSync()
+		return m, nil
+
+	case "x":
+		m.syncDeletes = !m.syncDeletes
+		m.replanSync()
+		return m, nil
+
+	case "c":
+		m.syncChecksum = !m.syncChecksum
+		return m, m.compareForSync()
+
+	case "enter":
+		if m.syncPlan == nil {
+			return m, nil
+		}
+		cmd := m.executeSync()
+		return m, cmd
+	}
+
+	var cmd tea.Cmd
+	m.syncViewport, cmd = m.syncViewport.Update(msg)
+	return m, cmd
+}
+
+// executeSync deletes what the plan deletes in the background, then
+// queues the copies. Deletes come first so replaced entries are out of the
+// way.
+func (m *model) executeSync() tea.Cmd {
+	plan := m.syncPlan
+	if len(plan.Actions) == 0 {
+		m.state = sftpView
+		m.syncPlan = nil
+		m.syncCompare = nil
+		m.message = "Already in sync"
+		return nil
+	}
+
+	var deletes []syncAction
+	for _, a := range plan.Actions {
+		if a.Kind == syncDeleteLeft || a.Kind == syncDeleteRight {
+			deletes = append(deletes, a)
+		}
+	}
+	left, right := m.leftFS(), fileSystem(m.sftpManager)
+	msg := syncDeletedMsg{plan: plan, leftRoot: m.localPath, rightRoot: m.remotePath}
+	if len(deletes) == 0 {
+		m.syncDeleted(msg)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.syncCancel = cancel
+	m.message = fmt.Sprintf("Deleting %d entries...", len(deletes))
+	return func() tea.Msg {
+		for _, a := range deletes {
+			if msg.err = ctx.Err(); msg.err != nil {
+				break
+			}
+			fsys, root := left, msg.leftRoot
+			if a.Kind == syncDeleteRight {
+				fsys, root = right, msg.rightRoot
+			}
+			target := fsPath(fsys, root, a.Path)
+			if a.IsDir {
+				msg.err = fsys.RemoveAll(target)
+			} else {
+				msg.err = fsys.Remove(target)
+			}
+			if msg.err != nil {
+				msg.failed = a.Path
+				break
+			}
+			msg.deleted++
+		}
+		return msg
+	}
+}
+
+// syncDeleted queues the copies of a sync once its deletes are done, unless
+// they failed or were stopped.
+func (m *model) syncDeleted(msg syncDeletedMsg) {
+	if m.syncCancel != nil {
+		m.syncCancel()
+		m.syncCancel = nil
+	}
+	m.syncPlan = nil
+	m.syncCompare = nil
+	if m.state != syncView {
+		return
+	}
+	m.state = sftpView
+	defer func() {
+		m.loadLocalFiles(m.localPath)
+		m.loadRemoteFiles(m.remotePath)
+	}()
+
+	switch {
+	case errors.Is(msg.err, context.Canceled):
+		m.message = fmt.Sprintf("Sync stopped after deleting %d entries", msg.deleted)
+		return
+	case msg.err != nil:
+		m.message = fmt.Sprintf("Error deleting %s, sync stopped: %v", msg.failed, msg.err)
+		return
+	}
+
+	left, right := m.leftFS(), fileSystem(m.sftpManager)
+	queued := 0
+	for _, a := range msg.plan.Actions {
+		switch a.Kind {
+		case syncCopyRight:
+			m.transferQueue.Enqueue(true, a.IsDir, false, fsPath(left, msg.leftRoot, a.Path), fsPath(right, msg.rightRoot, a.Path))
+		case syncCopyLeft:
+			m.transferQueue.Enqueue(false, a.IsDir, false, fsPath(right, msg.rightRoot, a.Path), fsPath(left, msg.leftRoot, a.Path))
+		default:
+			continue
+		}
+		queued++
+	}
+	m.message = fmt.Sprintf("Sync: queued %d copies, deleted %d entries", queued, msg.deleted)
+}
+
+// paneLabel names a side of the SFTP view
+func (m model) paneLabel(left bool) string {
+	switch {
+	case left && m.leftServer != nil:
+		return m.leftServer.Name
+	case left:
+		return "local"
+	case m.leftServer != nil:
+		return m.selectedServer.Name
+	}
+	return "remote"
+}
+
+func (m model) syncModeLabel() string {
+	left, right := m.paneLabel(true), m.paneLabel(false)
+	switch m.syncMode {
+	case syncToLeft:
+		return fmt.Sprintf("mirror %s → %s", right, left)
+	case syncBothWays:
+		return fmt.Sprintf("two-way %s ⇄ %s", left, right)
+	}
+	return fmt.Sprintf("mirror %s → %s", left, right)
+}
+
+// syncPlanLines renders the actions of the plan for the viewport
+func (m model) syncPlanLines() string {
+	plan := m.syncPlan
+	if len(plan.Actions) == 0 && len(plan.Conflicts) == 0 {
+		return helpStyle.Render("Nothing to do, the directories are in sync.")
+	}
+
+	var b strings.Builder
+	for _, a := range plan.Actions {
+		name := a.Path
+		if a.IsDir {
+			name += "/"
+		}
+		var line string
+		switch a.Kind {
+		case syncCopyRight:
+			line = fmt.Sprintf("  → copy    %9s  %-8s %s", FormatSize(a.Size), a.Reason, name)
+		case syncCopyLeft:
+			line = fmt.Sprintf("  ← copy    %9s  %-8s %s", FormatSize(a.Size), a.Reason, name)
+		case syncDeleteRight:
+			line = errorStyle.Render(fmt.Sprintf("✗ delete  %9s  %-8s %s: %s", FormatSize(a.Size), a.Reason, m.paneLabel(false), name))
+		case syncDeleteLeft:
+			line = errorStyle.Render(fmt.Sprintf("✗ delete  %9s  %-8s %s: %s", FormatSize(a.Size), a.Reason, m.paneLabel(true), name))
+		}
+		b.WriteString(line + "\n")
+	}
+	for _, rel := range plan.Conflicts {
+		b.WriteString(helpStyle.Render("! skip conflict: "+rel) + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (m model) viewSync() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Sync preview: "+m.syncModeLabel()) + "\n")
+	b.WriteString(helpStyle.Render(m.localPath+"  ⇄  "+m.remotePath) + "\n\n")
+
+	plan := m.syncPlan
+	if plan == nil {
+		how := "size and date"
+		if m.syncChecksum {
+			how = "checksum"
+		}
+		b.WriteString(messageStyle.Render(fmt.Sprintf("Comparing by %s...", how)) + "\n")
+	} else {
+		var toRight, toLeft, deletes int
+		var rightBytes, leftBytes int64
+		for _, a := range plan.Actions {
+			switch a.Kind {
+			case syncCopyRight:
+				toRight++
+				rightBytes += a.Size
+			case syncCopyLeft:
+				toLeft++
+				leftBytes += a.Size
+			default:
+				deletes++
+			}
+		}
+		summary := fmt.Sprintf("→ %d (%s) • ← %d (%s) • ✗ %d", toRight, FormatSize(rightBytes), toLeft, FormatSize(leftBytes), deletes)
+		if len(plan.Conflicts) > 0 {
+			summary += fmt.Sprintf(" • %d conflicts", len(plan.Conflicts))
+		}
+		if plan.Skipped > 0 {
+			summary += fmt.Sprintf(" • %d skipped", plan.Skipped)
+		}
+		b.WriteString(helpStyle.Render(summary) + "\n\n")
+		b.WriteString(m.syncViewport.View() + "\n")
+	}
+
+	onOff := func(on bool) string {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+	if m.syncCancel != nil {
+		b.WriteString("\n" + helpStyle.Render("[esc] stop deleting"))
+	} else {
+		b.WriteString("\n" + helpStyle.Render(fmt.Sprintf("[enter] run • [tab] direction • [x] deletions: %s • [c] checksums: %s • [esc] cancel", onOff(m.syncDeletes), onOff(m.syncChecksum))))
+	}
+
+	if m.message != "" {
+		msgStyle := messageStyle
+		if strings.HasPrefix(m.message, "Error") {
+			msgStyle = errorStyle
+		}
+		b.WriteString("\n" + msgStyle.Render(m.message))
+	}
+	return b.String()
+}
+
+// newSyncViewport returns the viewport that lists a sync plan
+func newSyncViewport() viewport.Model {
+	return viewport.New(0, 0)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeSyncFixture builds two directory trees that differ in every way a
+// sync can see.
+func writeSyncFixture(t *testing.T) (left, right string) {
+	t.Helper()
+	left, right = t.TempDir(), t.TempDir()
+	older := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	newer := older.Add(time.Hour)
+	write := func(root, rel, content string, mtime time.Time) {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(left, "same", "x", older)
+	write(right, "same", "x", older)
+	write(left, "changed", "abc", newer) // same size, left is newer
+	write(right, "changed", "abd", older)
+	write(left, "rnewer", "l", older) // right is newer
+	write(right, "rnewer", "r", newer)
+	write(left, "touched", "t", newer) // same content, left touched later
+	write(right, "touched", "t", older)
+	write(left, "clash", "aa", older) // same size and time, other content
+	write(right, "clash", "bb", older)
+	write(left, "newdir/a", "1", older)
+	write(left, "onlyleft", "o", older)
+	write(right, "extra/z", "zz", older)
+	write(left, "conf", "f", older) // a file here, a directory there
+	if err := os.Mkdir(filepath.Join(right, "conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(left, "onlyleft.part", "partial", older)
+	write(right, "same.part-info", "1 1\n", older)
+	if err := os.Symlink("same", filepath.Join(left, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return left, right
+}
+
+func TestCompareTrees(t *testing.T) {
+	left, right := writeSyncFixture(t)
+	cmp, err := compareTrees(localFS{}, left, localFS{}, right, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"onlyleft.part", "same.part-info", "link"} {
+		if cmp.left.entries[rel] != nil || cmp.right.entries[rel] != nil {
+			t.Errorf("%s was scanned", rel)
+		}
+	}
+	if cmp.left.skipped != 1 || cmp.right.skipped != 0 {
+		t.Errorf("skipped = %d, %d; want 1, 0", cmp.left.skipped, cmp.right.skipped)
+	}
+	if got := cmp.left.size("newdir"); got != 1 {
+		t.Errorf("size of newdir = %d, want 1", got)
+	}
+	if got := cmp.right.size("extra"); got != 2 {
+		t.Errorf("size of extra = %d, want 2", got)
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	left, right := writeSyncFixture(t)
+	cmp, err := compareTrees(localFS{}, left, localFS{}, right, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		mode          syncMode
+		deletes       bool
+		wantActions   []syncAction
+		wantConflicts []string
+	}{
+		{
+			name: "to right",
+			mode: syncToRight,
+			wantActions: []syncAction{
+				{Kind: syncCopyRight, Path: "changed", Size: 3, Reason: "changed"},
+				{Kind: syncCopyRight, Path: "newdir", IsDir: true, Size: 1, Reason: "new"},
+				{Kind: syncCopyRight, Path: "onlyleft", Size: 1, Reason: "new"},
+				{Kind: syncCopyRight, Path: "rnewer", Size: 1, Reason: "changed"},
+				{Kind: syncCopyRight, Path: "touched", Size: 1, Reason: "changed"},
+			},
+			wantConflicts: []string{"conf"},
+		},
+		{
+			name:    "to right with deletes",
+			mode:    syncToRight,
+			deletes: true,
+			wantActions: []syncAction{
+				{Kind: syncCopyRight, Path: "changed", Size: 3, Reason: "changed"},
+				{Kind: syncDeleteRight, Path: "conf", IsDir: true, Reason: "replaced"},
+				{Kind: syncCopyRight, Path: "conf", Size: 1, Reason: "replaces"},
+				{Kind: syncDeleteRight, Path: "extra", IsDir: true, Size: 2, Reason: "extra"},
+				{Kind: syncCopyRight, Path: "newdir", IsDir: true, Size: 1, Reason: "new"},
+				{Kind: syncCopyRight, Path: "onlyleft", Size: 1, Reason: "new"},
+				{Kind: syncCopyRight, Path: "rnewer", Size: 1, Reason: "changed"},
+				{Kind: syncCopyRight, Path: "touched", Size: 1, Reason: "changed"},
+			},
+		},
+		{
+			name: "to left",
+			mode: syncToLeft,
+			wantActions: []syncAction{
+				{Kind: syncCopyLeft, Path: "changed", Size: 3, Reason: "changed"},
+				{Kind: syncCopyLeft, Path: "extra", IsDir: true, Size: 2, Reason: "new"},
+				{Kind: syncCopyLeft, Path: "rnewer", Size: 1, Reason: "changed"},
+				{Kind: syncCopyLeft, Path: "touched", Size: 1, Reason: "changed"},
+			},
+			wantConflicts: []string{"conf"},
+		},
+		{
+			name:    "to left with deletes",
+			mode:    syncToLeft,
+			deletes: true,
+			wantActions: []syncAction{
+				{Kind: syncCopyLeft, Path: "changed", Size: 3, Reason: "changed"},
+				{Kind: syncDeleteLeft, Path: "conf", Size: 1, Reason: "replaced"},
+				{Kind: syncCopyLeft, Path: "conf", IsDir: true, Reason: "replaces"},
+				{Kind: syncCopyLeft, Path: "extra", IsDir: true, Size: 2, Reason: "new"},
+				{Kind: syncDeleteLeft, Path: "newdir", IsDir: true, Size: 1, Reason: "extra"},
+				{Kind: syncDeleteLeft, Path: "onlyleft", Size: 1, Reason: "extra"},
+				{Kind: syncCopyLeft, Path: "rnewer", Size: 1, Reason: "changed"},
+				{Kind: syncCopyLeft, Path: "touched", Size: 1, Reason: "changed"},
+			},
+		},
+		{
+			name: "both ways",
+			mode: syncBothWays,
+			wantActions: []syncAction{
+				{Kind: syncCopyRight, Path: "changed", Size: 3, Reason: "newer"},
+				{Kind: syncCopyLeft, Path: "extra", IsDir: true, Size: 2, Reason: "new"},
+				{Kind: syncCopyRight, Path: "newdir", IsDir: true, Size: 1, Reason: "new"},
+				{Kind: syncCopyRight, Path: "onlyleft", Size: 1, Reason: "new"},
+				{Kind: syncCopyLeft, Path: "rnewer", Size: 1, Reason: "newer"},
+				{Kind: syncCopyRight, Path: "touched", Size: 1, Reason: "newer"},
+			},
+			wantConflicts: []string{"conf"},
+		},
+		{
+			name:    "both ways ignores deletes",
+			mode:    syncBothWays,
+			deletes: true,
+			wantActions: []syncAction{
+				{Kind: syncCopyRight, Path: "changed", Size: 3, Reason: "newer"},
+				{Kind: syncCopyLeft, Path: "extra", IsDir: true, Size: 2, Reason: "new"},
+				{Kind: syncCopyRight, Path: "newdir", IsDir: true, Size: 1, Reason: "new"},
+				{Kind: syncCopyRight, Path: "onlyleft", Size: 1, Reason: "new"},
+				{Kind: syncCopyLeft, Path: "rnewer", Size: 1, Reason: "newer"},
+				{Kind: syncCopyRight, Path: "touched", Size: 1, Reason: "newer"},
+			},
+			wantConflicts: []string{"conf"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planSync(cmp, tt.mode, tt.deletes)
+			if !reflect.DeepEqual(plan.Actions, tt.wantActions) {
+				t.Errorf("actions:\n got %+v\nwant %+v", plan.Actions, tt.wantActions)
+			}
+			if !reflect.DeepEqual(plan.Conflicts, tt.wantConflicts) {
+				t.Errorf("conflicts = %q, want %q", plan.Conflicts, tt.wantConflicts)
+			}
+			if plan.Skipped != 1 {
+				t.Errorf("skipped = %d, want 1", plan.Skipped)
+			}
+		})
+	}
+}
+
+func TestPlanSyncChecksum(t *testing.T) {
+	left, right := writeSyncFixture(t)
+	cmp, err := compareTrees(localFS{}, left, localFS{}, right, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"same": true, "changed": false, "rnewer": false, "touched": true, "clash": false}
+	if !reflect.DeepEqual(cmp.sameContent, want) {
+		t.Errorf("sameContent = %v, want %v", cmp.sameContent, want)
+	}
+
+	plan := planSync(cmp, syncBothWays, false)
+	wantActions := []syncAction{
+		{Kind: syncCopyRight, Path: "changed", Size: 3, Reason: "newer"},
+		{Kind: syncCopyLeft, Path: "extra", IsDir: true, Size: 2, Reason: "new"},
+		{Kind: syncCopyRight, Path: "newdir", IsDir: true, Size: 1, Reason: "new"},
+		{Kind: syncCopyRight, Path: "onlyleft", Size: 1, Reason: "new"},
+		{Kind: syncCopyLeft, Path: "rnewer", Size: 1, Reason: "newer"},
+	}
+	if !reflect.DeepEqual(plan.Actions, wantActions) {
+		t.Errorf("actions:\n got %+v\nwant %+v", plan.Actions, wantActions)
+	}
+	if want := []string{"clash", "conf"}; !reflect.DeepEqual(plan.Conflicts, want) {
+		t.Errorf("conflicts = %q, want %q", plan.Conflicts, want)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	tagStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
+	tagActiveStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("0")).Background(lipgloss.Color("170"))
+	tagCursorStyle = lipgloss.NewStyle().Underline(true)
+)
+
+// parseTags splits a comma or space separated list of tags such as
+// "env:prod, role:db", dropping duplicates.
+func parseTags(s string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, tag := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// hasTags reports whether the server carries all of tags
+func (s Server) hasTags(tags []string) bool {
+	for _, want := range tags {
+		found := false
+		for _, tag := range s.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// allTags returns the tags used by any server, sorted
+func (c *Config) allTags() []string {
+	seen := map[string]bool{}
+	var tags []string
+	for _, s := range c.Servers {
+		for _, tag := range s.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// --- Tag facet bar ---
+
+// tagActive reports whether the list is narrowed by tag
+func (m model) tagActive(tag string) bool {
+	for _, t := range m.tagFilter {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// toggleTag adds tag to the facets the list is narrowed by, or removes it
+func (m *model) toggleTag(tag string) {
+	var tags []string
+	for _, t := range m.tagFilter {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) == len(m.tagFilter) {
+		tags = append(tags, tag)
+	}
+	m.tagFilter = tags
+	m.refreshList()
+}
+
+// pruneTagFilter drops facets no server carries any more
+func (m *model) pruneTagFilter() {
+	used := map[string]bool{}
+	for _, tag := range m.config.allTags() {
+		used[tag] = true
+	}
+	var tags []string
+	for _, t := range m.tagFilter {
+		if used[t] {
+			tags = append(tags, t)
+		}
+	}
+	m.tagFilter = tags
+}
+
+// updateTagBar handles keys while the facet bar has the focus
+func (m model) updateTagBar(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	tags := m.config.allTags()
+	if len(tags) == 0 {
+		m.tagFocus = false
+		return m, nil
+	}
+	if m.tagCursor >= len(tags) {
+		m.tagCursor = len(tags) - 1
+	}
+
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "T", "q":
+		m.tagFocus = false
+	case "left", "h":
+		if m.tagCursor > 0 {
+			m.tagCursor--
+		}
+	case "right", "l":
+		if m.tagCursor < len(tags)-1 {
+			m.tagCursor++
+		}
+	case " ", "enter":
+		m.toggleTag(tags[m.tagCursor])
+	case "c":
+		m.tagFilter = nil
+		m.refreshList()
+	}
+	return m, nil
+}
+
+// viewTagBar lists the tags in use, active ones highlighted, or returns ""
+// if no server is tagged.
+func (m model) viewTagBar() string {
+	tags := m.config.allTags()
+	if len(tags) == 0 {
+		return ""
+	}
+	parts := make([]string, len(tags))
+	for i, tag := range tags {
+		style := tagStyle
+		if m.tagActive(tag) {
+			style = tagActiveStyle
+		}
+		if m.tagFocus && i == m.tagCursor {
+			style = style.Copy().Inherit(tagCursorStyle)
+		}
+		parts[i] = style.Render(tag)
+	}
+	bar := "  Tags: " + strings.Join(parts, " ")
+	if m.tagFocus {
+		bar += "\n" + helpStyle.Render("[←/→] move • [space] narrow by tag • [c]lear • [esc] back to list")
+	}
+	return bar
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{" , ,", nil},
+		{"env:prod", []string{"env:prod"}},
+		{"env:prod, role:db", []string{"env:prod", "role:db"}},
+		{"env:prod,role:db", []string{"env:prod", "role:db"}},
+		{"env:prod role:db  web", []string{"env:prod", "role:db", "web"}},
+		{"web, db, web,db", []string{"web", "db"}},
+		{"Web web", []string{"Web", "web"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := parseTags(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTags(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasTags(t *testing.T) {
+	s := Server{Tags: []string{"env:prod", "role:db"}}
+	tests := []struct {
+		name string
+		tags []string
+		want bool
+	}{
+		{"no filter", nil, true},
+		{"one", []string{"role:db"}, true},
+		{"all", []string{"role:db", "env:prod"}, true},
+		{"one missing", []string{"env:prod", "role:web"}, false},
+		{"prefix only", []string{"env"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.hasTags(tt.tags); got != tt.want {
+				t.Errorf("hasTags(%q) = %v, want %v", tt.tags, got, tt.want)
+			}
+		})
+	}
+	if (Server{}).hasTags([]string{"web"}) {
+		t.Error("untagged server has tag web")
+	}
+}
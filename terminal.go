@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/muesli/cancelreader"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// sshSessionEndedMsg is sent when an interactive session returns control
+type sshSessionEndedMsg struct {
+	server string
+	err    error
+}
+
+// sshTerminal is an interactive shell over an established SSH client. It
+// implements tea.ExecCommand so Bubble Tea hands it the terminal the same way
+// it would an external process.
+type sshTerminal struct {
+	client       *ssh.Client
+	forwardAgent bool
+	stdin        io.Reader
+	stdout       io.Writer
+	stderr       io.Writer
+}
+
+func newSSHTerminal(client *ssh.Client, forwardAgent bool) *sshTerminal {
+	return &sshTerminal{
+		client:       client,
+		forwardAgent: forwardAgent,
+		stdin:        os.Stdin,
+		stdout:       os.Stdout,
+		stderr:       os.Stderr,
+	}
+}
+
+func (t *sshTerminal) SetStdin(r io.Reader)  { t.stdin = r }
+func (t *sshTerminal) SetStdout(w io.Writer) { t.stdout = w }
+func (t *sshTerminal) SetStderr(w io.Writer) { t.stderr = w }
+
+// Run requests a PTY, starts a shell and passes raw input through until the
+// remote shell exits. The client is closed afterwards.
+func (t *sshTerminal) Run() error {
+	defer t.client.Close()
+
+	session, err := t.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// A failed forward leaves the shell usable, so it is only reported
+	if t.forwardAgent {
+		if err := forwardAgent(t.client, session); err != nil {
+			fmt.Fprintf(t.stderr, "Agent forwarding failed: %v\r\n", err)
+		}
+	}
+
+	inFd := fileDescriptor(t.stdin, os.Stdin)
+	outFd := fileDescriptor(t.stdout, os.Stdout)
+
+	width, height, err := term.GetSize(outFd)
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return err
+	}
+
+	if term.IsTerminal(inFd) {
+		oldState, err := term.MakeRaw(inFd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(inFd, oldState)
+	}
+
+	// Stdin is copied by hand from a cancelable reader: a plain io.Copy would
+	// keep blocking on the terminal after the shell exits and swallow the
+	// next keypress meant for the TUI.
+	stdin, err := cancelreader.NewReader(t.stdin)
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+
+	remoteStdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	session.Stdout = t.stdout
+	session.Stderr = t.stderr
+
+	if err := session.Shell(); err != nil {
+		return err
+	}
+
+	copyDone := make(chan struct{})
+	go func() {
+		defer close(copyDone)
+		io.Copy(remoteStdin, stdin)
+		remoteStdin.Close()
+	}()
+
+	// Bubble Tea's event loop (and with it tea.WindowSizeMsg) is suspended
+	// while the session owns the terminal, so resizes are tracked here.
+	stopResize := watchWindowSize(outFd, func(width, height int) {
+		session.WindowChange(height, width)
+	})
+	defer stopResize()
+
+	err = session.Wait()
+	stdin.Cancel()
+	<-copyDone
+
+	// The exit status of the last command run in the shell is not an error
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+	return err
+}
+
+// fileDescriptor returns the descriptor behind v, or fallback's descriptor
+// if v is not a file.
+func fileDescriptor(v interface{}, fallback *os.File) int {
+	if f, ok := v.(interface{ Fd() uintptr }); ok {
+		return int(f.Fd())
+	}
+	return int(fallback.Fd())
+}
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// progressInterval throttles how often progress reaches the UI
+	progressInterval = 100 * time.Millisecond
+
+	// queueMaxRows is how many jobs the queue panel shows at once
+	queueMaxRows = 5
+
+	defaultTransferWorkers = 3
+	maxTransferWorkers     = 8
+)
+
+// errJobPaused is the cancel cause used when a job is paused
+var errJobPaused = errors.New("paused")
+
+// transferProgressMsg reports bytes done, throughput and ETA of a transfer
+type transferProgressMsg struct {
+	done  int64
+	total int64
+	rate  float64 // bytes per second
+	eta   time.Duration
+}
+
+// queueUpdateMsg tells the UI that the transfer queue changed
+type queueUpdateMsg struct{}
+
+// progressWriter counts bytes written through it and reports throttled
+// snapshots. It is used as an extra sink next to the destination file, so
+// it never sees the data twice.
+type progressWriter struct {
+	mu       sync.Mutex
+	total    int64
+	done     int64
+	resumed  int64 // bytes done by earlier attempts, excluded from the rate
+	start    time.Time
+	lastSent time.Time
+	report   func(transferProgressMsg)
+}
+
+func newProgressWriter(total int64, report func(transferProgressMsg)) *progressWriter {
+	return &progressWriter{total: total, start: time.Now(), report: report}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += int64(len(b))
+	if time.Since(p.lastSent) >= progressInterval {
+		p.lastSent = time.Now()
+		p.report(p.snapshot())
+	}
+	return len(b), nil
+}
+
+// Resume counts n bytes that an interrupted earlier attempt already copied
+func (p *progressWriter) Resume(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += n
+	p.resumed += n
+}
+
+func (p *progressWriter) snapshot() transferProgressMsg {
+	msg := transferProgressMsg{done: p.done, total: p.total}
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		msg.rate = float64(p.done-p.resumed) / elapsed
+	}
+	if msg.rate > 0 && p.total > p.done {
+		msg.eta = time.Duration(float64(p.total-p.done) / msg.rate * float64(time.Second))
+	}
+	return msg
+}
+
+// formatTransferStatus renders bytes done, throughput and ETA
+func formatTransferStatus(p transferProgressMsg) string {
+	status := fmt.Sprintf("%s / %s", FormatSize(p.done), FormatSize(p.total))
+	if p.rate > 0 {
+		status += fmt.Sprintf(" • %s/s", FormatSize(int64(p.rate)))
+	}
+	if p.eta > 0 {
+		status += fmt.Sprintf(" • ETA %s", p.eta.Round(time.Second))
+	}
+	return status
+}
+
+// --- Transfer queue ---
+
+type jobState int
+
+const (
+	jobQueued jobState = iota
+	jobRunning
+	jobPaused
+	jobDone
+	jobFailed
+	jobCancelled
+)
+
+func (s jobState) String() string {
+	switch s {
+	case jobQueued:
+		return "queued"
+	case jobRunning:
+		return "running"
+	case jobPaused:
+		return "paused"
+	case jobDone:
+		return "done"
+	case jobFailed:
+		return "failed"
+	case jobCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
+
+// transferJob is one queued upload or download of a file or directory
+type transferJob struct {
+	ID       int
+	Upload   bool // left pane to right pane; otherwise right to left
+	IsDir    bool
+	Move     bool // delete the source once the copy has completed
+	Src      string
+	Dst      string
+	State    jobState
+	Progress transferProgressMsg
+	Message  string // result or error shown when the job finishes
+	cancel   context.CancelCauseFunc
+}
+
+// transferQueue runs transfer jobs between the two panes of the SFTP view
+// with a bounded number of concurrent workers. The left side is the local
+// disk, or a second server in remote-to-remote mode.
+type transferQueue struct {
+	mu       sync.Mutex
+	left     fileSystem
+	right    fileSystem
+	workers  int
+	opts     transferOptions
+	running  int
+	nextID   int
+	jobs     []*transferJob
+	finished []transferJob // jobs finished since the UI last drained them
+	closed   bool
+	updates  chan tea.Msg
+}
+
+func newTransferQueue(left, right fileSystem, workers int, opts transferOptions) *transferQueue {
+	if workers < 1 {
+		workers = defaultTransferWorkers
+	}
+	return &transferQueue{
+		left:    left,
+		right:   right,
+		workers: workers,
+		opts:    opts,
+		nextID:  1,
+		updates: make(chan tea.Msg, 1),
+	}
+}
+
+// waitForQueue delivers the next queue notification to Update
+func waitForQueue(q *transferQueue) tea.Cmd {
+	return func() tea.Msg {
+		return <-q.updates
+	}
+}
+
+// notify wakes the UI without blocking; one pending notification is
+// enough since the UI reads the whole queue state. Callers hold q.mu.
+func (q *transferQueue) notify() {
+	if q.closed {
+		return
+	}
+	select {
+	case q.updates <- queueUpdateMsg{}:
+	default:
+	}
+}
+
+// Enqueue adds a job and starts it if a worker is free
+func (q *transferQueue) Enqueue(upload, isDir, move bool, src, dst string) *transferJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := &transferJob{ID: q.nextID, Upload: upload, IsDir: isDir, Move: move, Src: src, Dst: dst}
+	q.nextID++
+	q.jobs = append(q.jobs, job)
+	q.schedule()
+	q.notify()
+	return job
+}
+
+// schedule starts queued jobs while workers are free. Callers hold q.mu.
+func (q *transferQueue) schedule() {
+	for _, job := range q.jobs {
+		if q.closed || q.running >= q.workers {
+			return
+		}
+		if job.State != jobQueued {
+			continue
+		}
+		ctx, cancel := context.WithCancelCause(context.Background())
+		job.cancel = cancel
+		job.State = jobRunning
+		job.Progress = transferProgressMsg{}
+		q.running++
+		go q.run(ctx, job, q.opts)
+	}
+}
+
+// run executes one job on a worker goroutine. Paused, failed and cancelled
+// file copies keep their partial destination, so running the job again
+// resumes it.
+func (q *transferQueue) run(ctx context.Context, job *transferJob, opts transferOptions) {
+	src, dst := q.left, q.right
+	if !job.Upload {
+		src, dst = q.right, q.left
+	}
+
+	var total int64
+	if job.IsDir {
+		total, _ = treeSize(src, job.Src)
+	} else if info, err := src.Stat(job.Src); err == nil {
+		total = info.Size()
+	}
+
+	progress := newProgressWriter(total, func(p transferProgressMsg) {
+		q.mu.Lock()
+		job.Progress = p
+		q.notify()
+		q.mu.Unlock()
+	})
+
+	// Remote-to-remote data streams through this client
+	done, doing := "Copied", "copying"
+	if _, local := q.left.(localFS); local && job.Upload {
+		done, doing = "Uploaded", "uploading"
+	} else if local {
+		done, doing = "Downloaded", "downloading"
+	}
+
+	var message string
+	var stats transferStats
+	var err error
+	name := filepath.Base(job.Src)
+	if job.IsDir {
+		stats, err = copyTree(ctx, src, job.Src, dst, job.Dst, opts, progress)
+		message = fmt.Sprintf("%s %s/: %s", done, name, stats)
+		if err != nil {
+			message = fmt.Sprintf("Error %s %s/ after %s: %v", doing, name, stats, err)
+		}
+	} else {
+		err = copyFile(ctx, src, job.Src, dst, job.Dst, opts, progress)
+		message = fmt.Sprintf("%s %s", done, name)
+		if err != nil {
+			message = fmt.Sprintf("Error %s: %v", doing, err)
+		}
+	}
+
+	// A move deletes its source only after a complete copy, so entries a
+	// tree copy skipped are not lost.
+	if job.Move && err == nil && ctx.Err() == nil {
+		switch {
+		case stats.Skipped > 0:
+			err = fmt.Errorf("%d entries not copied", stats.Skipped)
+			message = fmt.Sprintf("Error moving %s/: copied it but kept the source, %d entries could not be moved", name, stats.Skipped)
+		case job.IsDir:
+			err = src.RemoveAll(job.Src)
+			message = fmt.Sprintf("Moved %s/: %s", name, stats)
+		default:
+			err = src.Remove(job.Src)
+			message = fmt.Sprintf("Moved %s", name)
+		}
+		if err != nil && stats.Skipped == 0 {
+			message = fmt.Sprintf("Error removing %s after copying it: %v", name, err)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job.Progress = progress.snapshot()
+	q.running--
+
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errJobPaused):
+		job.State = jobPaused
+		job.Message = fmt.Sprintf("Paused %s", name)
+	case cause != nil:
+		job.State = jobCancelled
+		job.Message = fmt.Sprintf("Cancelled %s", name)
+	case err != nil:
+		job.State = jobFailed
+		job.Message = message
+	default:
+		job.State = jobDone
+		job.Message = message
+	}
+	job.cancel(nil)
+	job.cancel = nil
+	q.finished = append(q.finished, *job)
+
+	q.schedule()
+	q.notify()
+}
+
+// find returns the job with the given ID. Callers hold q.mu.
+func (q *transferQueue) find(id int) *transferJob {
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// TogglePause pauses a queued or running job, or resumes a paused one
+func (q *transferQueue) TogglePause(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.find(id)
+	if job == nil {
+		return
+	}
+	switch job.State {
+	case jobRunning:
+		job.cancel(errJobPaused)
+	case jobQueued:
+		job.State = jobPaused
+	case jobPaused:
+		job.State = jobQueued
+		q.schedule()
+	}
+	q.notify()
+}
+
+// Cancel stops a job that has not finished
+func (q *transferQueue) Cancel(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.find(id)
+	if job == nil {
+		return
+	}
+	switch job.State {
+	case jobRunning:
+		job.cancel(context.Canceled)
+	case jobQueued, jobPaused:
+		job.State = jobCancelled
+		job.Message = fmt.Sprintf("Cancelled %s", filepath.Base(job.Src))
+	}
+	q.notify()
+}
+
+// Retry re-queues a failed or cancelled job
+func (q *transferQueue) Retry(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.find(id)
+	if job == nil || (job.State != jobFailed && job.State != jobCancelled) {
+		return
+	}
+	job.State = jobQueued
+	job.Message = ""
+	q.schedule()
+	q.notify()
+}
+
+// ClearFinished removes completed and cancelled jobs from the list
+func (q *transferQueue) ClearFinished() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	kept := q.jobs[:0]
+	for _, job := range q.jobs {
+		if job.State != jobDone && job.State != jobCancelled {
+			kept = append(kept, job)
+		}
+	}
+	q.jobs = kept
+	q.notify()
+}
+
+// SetWorkers changes the number of concurrent transfers
+func (q *transferQueue) SetWorkers(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n < 1 || n > maxTransferWorkers {
+		return
+	}
+	q.workers = n
+	q.schedule()
+	q.notify()
+}
+
+// SetOptions changes the copy options of jobs started from now on
+func (q *transferQueue) SetOptions(opts transferOptions) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.opts = opts
+}
+
+// Close cancels every unfinished job and stops notifying the UI
+func (q *transferQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.State == jobRunning {
+			job.cancel(context.Canceled)
+		}
+	}
+	q.closed = true
+	close(q.updates)
+}
+
+// Snapshot returns copies of the jobs for rendering
+func (q *transferQueue) Snapshot() []transferJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]transferJob, len(q.jobs))
+	for i, job := range q.jobs {
+		jobs[i] = *job
+		jobs[i].cancel = nil
+	}
+	return jobs
+}
+
+// DrainFinished returns the jobs finished since the last call
+func (q *transferQueue) DrainFinished() []transferJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	finished := q.finished
+	q.finished = nil
+	return finished
+}
+
+// Workers returns the number of concurrent transfers
+func (q *transferQueue) Workers() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.workers
+}
+
+// transferWriter wraps the destination of a copy: writes fail once ctx is
+// done, and everything written is also fed to progress if it is set.
+func transferWriter(ctx context.Context, w io.Writer, progress io.Writer) io.Writer {
+	return &ctxWriter{ctx: ctx, w: withProgress(w, progress)}
+}
+
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *ctxWriter) Write(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(b)
+}
+
+// --- Queue panel ---
+
+// refreshTransferStatus aggregates the running jobs into the progress header
+func (m *model) refreshTransferStatus() {
+	var status transferProgressMsg
+	var running []transferJob
+	for _, job := range m.transferQueue.Snapshot() {
+		if job.State == jobRunning {
+			running = append(running, job)
+			status.done += job.Progress.done
+			status.total += job.Progress.total
+			status.rate += job.Progress.rate
+		}
+	}
+	if status.rate > 0 && status.total > status.done {
+		status.eta = time.Duration(float64(status.total-status.done) / status.rate * float64(time.Second))
+	}
+
+	m.isTransferring = len(running) > 0
+	m.transferStatus = status
+	m.transferProgress = 0
+	if status.total > 0 {
+		m.transferProgress = int(status.done * 100 / status.total)
+	}
+	switch len(running) {
+	case 0:
+		m.transferMessage = ""
+	case 1:
+		m.transferMessage = fmt.Sprintf("Copying %s...", filepath.Base(running[0].Src))
+	default:
+		m.transferMessage = fmt.Sprintf("Copying %d items...", len(running))
+	}
+}
+
+// queuePanelHeight returns the lines used by the queue panel, 0 if hidden
+func (m model) queuePanelHeight() int {
+	if m.transferQueue == nil {
+		return 0
+	}
+	n := len(m.transferQueue.Snapshot())
+	if n == 0 {
+		return 0
+	}
+	if n > queueMaxRows {
+		n = queueMaxRows
+	}
+	return n + 2
+}
+
+// updateQueuePanel handles keys while the queue panel has focus and
+// reports whether the key was consumed.
+func (m *model) updateQueuePanel(msg tea.KeyMsg) bool {
+	q := m.transferQueue
+	if q == nil {
+		return false
+	}
+	jobs := q.Snapshot()
+	if len(jobs) == 0 {
+		m.focusPane = "local"
+		return false
+	}
+	if m.queueCursor >= len(jobs) {
+		m.queueCursor = len(jobs) - 1
+	}
+	selected := jobs[m.queueCursor]
+
+	switch msg.String() {
+	case "up", "k":
+		if m.queueCursor > 0 {
+			m.queueCursor--
+		}
+	case "down", "j":
+		if m.queueCursor < len(jobs)-1 {
+			m.queueCursor++
+		}
+	case "p", " ":
+		q.TogglePause(selected.ID)
+	case "x":
+		q.Cancel(selected.ID)
+	case "r":
+		q.Retry(selected.ID)
+	case "C":
+		q.ClearFinished()
+		m.queueCursor = 0
+	case "+", "-":
+		workers := q.Workers() + 1
+		if msg.String() == "-" {
+			workers = q.Workers() - 1
+		}
+		if workers < 1 || workers > maxTransferWorkers {
+			return true
+		}
+		q.SetWorkers(workers)
+		m.config.TransferWorkers = workers
+		if err := m.saveConfig(); err != nil {
+			m.message = fmt.Sprintf("Error saving: %v", err)
+		} else {
+			m.message = fmt.Sprintf("%d concurrent transfers", workers)
+		}
+	case "c", "m", "d", "e", "f", "n", "o", "*", "y", "!", "enter":
+		// File actions do not apply to the queue
+	default:
+		return false
+	}
+	return true
+}
+
+func (m model) viewTransferQueue() string {
+	var b strings.Builder
+	jobs := m.transferQueue.Snapshot()
+
+	counts := map[jobState]int{}
+	for _, job := range jobs {
+		counts[job.State]++
+	}
+	header := fmt.Sprintf("QUEUE  %d running • %d queued • %d workers", counts[jobRunning], counts[jobQueued], m.transferQueue.Workers())
+	if m.focusPane == "queue" {
+		header = "> " + header + " <"
+	}
+	b.WriteString(helpStyle.Render(header) + "\n")
+
+	// Keep the cursor inside the visible window
+	start := 0
+	if m.queueCursor >= queueMaxRows {
+		start = m.queueCursor - queueMaxRows + 1
+	}
+	end := start + queueMaxRows
+	if end > len(jobs) {
+		end = len(jobs)
+	}
+
+	stateStyle := map[jobState]lipgloss.Style{
+		jobRunning: messageStyle.Copy().UnsetMarginLeft(),
+		jobFailed:  errorStyle.Copy().UnsetMarginLeft(),
+	}
+
+	for i := start; i < end; i++ {
+		job := jobs[i]
+		cursor := "  "
+		if m.focusPane == "queue" && i == m.queueCursor {
+			cursor = "> "
+		}
+		arrow := "↓"
+		switch {
+		case m.leftManager != nil && job.Upload:
+			arrow = "→"
+		case m.leftManager != nil:
+			arrow = "←"
+		case job.Upload:
+			arrow = "↑"
+		}
+		name := filepath.Base(job.Src)
+		if job.IsDir {
+			name += "/"
+		}
+		if job.Move {
+			name += " (move)"
+		}
+
+		state := fmt.Sprintf("%-9s", job.State)
+		if style, ok := stateStyle[job.State]; ok {
+			state = style.Render(state)
+		}
+
+		detail := ""
+		switch job.State {
+		case jobRunning, jobPaused:
+			percent := 0
+			if job.Progress.total > 0 {
+				percent = int(job.Progress.done * 100 / job.Progress.total)
+			}
+			detail = fmt.Sprintf("%3d%%  %s", percent, formatTransferStatus(job.Progress))
+		case jobFailed:
+			detail = job.Message
+		}
+
+		b.WriteString(fmt.Sprintf("  %s%s %s %-30s %s\n", cursor, arrow, state, name, detail))
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	vaultVersion   = 1
+	vaultKDF       = "scrypt"
+	vaultMinLength = 8
+)
+
+// vaultAAD binds the ciphertext to this application and format version
+var vaultAAD = []byte("termius-from-walmart vault v1")
+
+var errWrongPassphrase = errors.New("wrong master passphrase")
+
+// vaultFile is the on-disk envelope of an encrypted config
+type vaultFile struct {
+	Version    int    `json:"vault_version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// vault holds the key derived from the master passphrase for the session
+type vault struct {
+	key     []byte
+	salt    []byte
+	n, r, p int
+}
+
+// parseVaultFile returns the envelope if data is an encrypted config, or nil
+// if it is a plaintext config.
+func parseVaultFile(data []byte) *vaultFile {
+	var vf vaultFile
+	if err := json.Unmarshal(data, &vf); err != nil {
+		return nil
+	}
+	if vf.Version == 0 || vf.KDF == "" {
+		return nil
+	}
+	return &vf
+}
+
+// newVault derives a key from the passphrase with a fresh random salt
+func newVault(passphrase string) (*vault, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	v := &vault{salt: salt, n: 1 << 15, r: 8, p: 1}
+	key, err := scrypt.Key([]byte(passphrase), v.salt, v.n, v.r, v.p, 32)
+	if err != nil {
+		return nil, err
+	}
+	v.key = key
+	return v, nil
+}
+
+// unlockVault derives the key for an existing envelope and decrypts it
+func unlockVault(vf *vaultFile, passphrase string) (*vault, []byte, error) {
+	if vf.Version != vaultVersion || vf.KDF != vaultKDF {
+		return nil, nil, fmt.Errorf("unsupported vault format %s v%d", vf.KDF, vf.Version)
+	}
+	key, err := scrypt.Key([]byte(passphrase), vf.Salt, vf.N, vf.R, vf.P, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := gcm.Open(nil, vf.Nonce, vf.Ciphertext, vaultAAD)
+	if err != nil {
+		return nil, nil, errWrongPassphrase
+	}
+	return &vault{key: key, salt: vf.Salt, n: vf.N, r: vf.R, p: vf.P}, plaintext, nil
+}
+
+// seal encrypts plaintext with a fresh nonce and returns the envelope as JSON
+func (v *vault) seal(plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(v.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	vf := vaultFile{
+		Version:    vaultVersion,
+		KDF:        vaultKDF,
+		Salt:       v.salt,
+		N:          v.n,
+		R:          v.r,
+		P:          v.p,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, vaultAAD),
+	}
+	return json.MarshalIndent(vf, "", "  ")
+}
+
+// matches reports whether passphrase derives the same key as the vault
+func (v *vault) matches(passphrase string) bool {
+	key, err := scrypt.Key([]byte(passphrase), v.salt, v.n, v.r, v.p, 32)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, v.key) == 1
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hasSecrets reports whether any server, group or identity stores a
+// password, private key or key passphrase
+func (c *Config) hasSecrets() bool {
+	for _, s := range c.Servers {
+		if s.Password != "" || s.PemKey != "" || s.KeyPassphrase != "" {
+			return true
+		}
+	}
+	for _, g := range c.Groups {
+		if g.PemKey != "" || g.KeyPassphrase != "" {
+			return true
+		}
+	}
+	for _, id := range c.Identities {
+		if id.PrivateKey != "" || id.Passphrase != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Vault TUI ---
+
+func newPassphraseInput(prompt, placeholder string) textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = placeholder
+	ti.CharLimit = 256
+	ti.Width = 40
+	ti.Prompt = prompt
+	ti.EchoMode = textinput.EchoPassword
+	ti.EchoCharacter = '•'
+	return ti
+}
+
+func (m *model) initUnlockInputs() {
+	m.vaultInputs = []textinput.Model{newPassphraseInput("Passphrase: ", "master passphrase")}
+	m.vaultInputs[0].Focus()
+	m.vaultFocus = 0
+}
+
+// initVaultSetupInputs prepares the form for setting or changing the master
+// passphrase. The current passphrase is asked for when a vault already exists.
+func (m *model) initVaultSetupInputs() {
+	m.vaultInputs = nil
+	if m.vault != nil {
+		m.vaultInputs = append(m.vaultInputs, newPassphraseInput("Current: ", "current master passphrase"))
+	}
+	m.vaultInputs = append(m.vaultInputs,
+		newPassphraseInput("New:     ", fmt.Sprintf("at least %d characters", vaultMinLength)),
+		newPassphraseInput("Confirm: ", "repeat new passphrase"),
+	)
+	m.vaultInputs[0].Focus()
+	m.vaultFocus = 0
+}
+
+func (m model) updateUnlockView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "esc":
+		return m, tea.Quit
+
+	case "enter":
+		v, plaintext, err := unlockVault(m.sealedConfig, m.vaultInputs[0].Value())
+		if err != nil {
+			m.message = fmt.Sprintf("Error: %v", err)
+			m.vaultInputs[0].SetValue("")
+			return m, nil
+		}
+		config := &Config{Servers: []Server{}, NextID: 1}
+		if err := json.Unmarshal(plaintext, config); err != nil {
+			m.message = fmt.Sprintf("Error: vault contents are corrupt: %v", err)
+			return m, nil
+		}
+		m.vault = v
+		m.sealedConfig = nil
+		m.config = config
+		m.vaultInputs = nil
+		m.refreshList()
+		m.state = listView
+		m.message = ""
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.vaultInputs[0], cmd = m.vaultInputs[0].Update(msg)
+	return m, cmd
+}
+
+func (m model) updateVaultSetupView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+
+	case "esc":
+		m.vaultInputs = nil
+		m.state = listView
+		m.message = ""
+		if m.vault == nil && m.config.hasSecrets() {
+			m.message = "Config left unencrypted (set a passphrase from the [m]enu)"
+		}
+		return m, nil
+
+	case "tab", "shift+tab", "up", "down":
+		s := msg.String()
+		if s == "up" || s == "shift+tab" {
+			m.vaultFocus--
+		} else {
+			m.vaultFocus++
+		}
+		if m.vaultFocus >= len(m.vaultInputs) {
+			m.vaultFocus = 0
+		} else if m.vaultFocus < 0 {
+			m.vaultFocus = len(m.vaultInputs) - 1
+		}
+		for i := range m.vaultInputs {
+			if i == m.vaultFocus {
+				m.vaultInputs[i].Focus()
+			} else {
+				m.vaultInputs[i].Blur()
+			}
+		}
+		return m, nil
+
+	case "enter":
+		if m.vaultFocus < len(m.vaultInputs)-1 {
+			m.vaultInputs[m.vaultFocus].Blur()
+			m.vaultFocus++
+			m.vaultInputs[m.vaultFocus].Focus()
+			return m, nil
+		}
+		if m.applyVaultSetup() {
+			m.vaultInputs = nil
+			m.state = listView
+		}
+		return m, nil
+	}
+
+	cmds := make([]tea.Cmd, len(m.vaultInputs))
+	for i := range m.vaultInputs {
+		m.vaultInputs[i], cmds[i] = m.vaultInputs[i].Update(msg)
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// applyVaultSetup validates the passphrase form and re-encrypts the config
+func (m *model) applyVaultSetup() bool {
+	inputs := m.vaultInputs
+	if m.vault != nil {
+		if !m.vault.matches(inputs[0].Value()) {
+			m.message = "Error: current passphrase is incorrect"
+			return false
+		}
+		inputs = inputs[1:]
+	}
+
+	passphrase := inputs[0].Value()
+	if len(passphrase) < vaultMinLength {
+		m.message = fmt.Sprintf("Error: passphrase must be at least %d characters", vaultMinLength)
+		return false
+	}
+	if passphrase != inputs[1].Value() {
+		m.message = "Error: passphrases do not match"
+		return false
+	}
+
+	v, err := newVault(passphrase)
+	if err != nil {
+		m.message = fmt.Sprintf("Error: %v", err)
+		return false
+	}
+	previous := m.vault
+	m.vault = v
+	if err := m.saveConfig(); err != nil {
+		m.vault = previous
+		m.message = fmt.Sprintf("Error saving: %v", err)
+		return false
+	}
+
+	if previous != nil {
+		m.message = "Master passphrase changed"
+	} else {
+		m.message = "Config encrypted with master passphrase"
+	}
+	return true
+}
+
+func (m model) viewUnlock() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("Unlock Vault") + "\n\n")
+	b.WriteString(helpStyle.Render("Your saved servers are encrypted. Enter the master passphrase.") + "\n\n")
+	b.WriteString(m.vaultInputs[0].View() + "\n\n")
+	b.WriteString(helpStyle.Render("Unlock: [enter] • Quit: [esc]"))
+
+	if m.message != "" {
+		b.WriteString("\n\n" + errorStyle.Render(m.message))
+	}
+
+	return b.String()
+}
+
+func (m model) viewVaultSetup() string {
+	var b strings.Builder
+
+	if m.vault != nil {
+		b.WriteString(titleStyle.Render("Change Master Passphrase") + "\n\n")
+	} else {
+		b.WriteString(titleStyle.Render("Encrypt Config") + "\n\n")
+		b.WriteString(helpStyle.Render("Passwords and PEM keys are stored in plaintext in "+m.configPath+".") + "\n")
+		b.WriteString(helpStyle.Render("Set a master passphrase to encrypt them; it is asked for at every start.") + "\n\n")
+	}
+
+	for i, input := range m.vaultInputs {
+		b.WriteString(input.View())
+		if i < len(m.vaultInputs)-1 {
+			b.WriteRune('\n')
+		}
+	}
+
+	b.WriteString("\n\n" + helpStyle.Render("Navigate: [tab]/[shift+tab] • Save: [enter] • Skip: [esc]"))
+
+	if m.message != "" {
+		msgStyle := messageStyle
+		if strings.HasPrefix(m.message, "Error") {
+			msgStyle = errorStyle
+		}
+		b.WriteString("\n\n" + msgStyle.Render(m.message))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVaultRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"servers":[{"name":"db","password":"hunter2"}]}`)
+	v, err := newVault("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := v.seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("hunter2")) {
+		t.Fatal("sealed config contains the plaintext password")
+	}
+
+	vf := parseVaultFile(sealed)
+	if vf == nil {
+		t.Fatal("sealed config not recognized as a vault")
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		wantErr    error
+	}{
+		{"right passphrase", "correct horse", nil},
+		{"wrong passphrase", "correct horse!", errWrongPassphrase},
+		{"empty passphrase", "", errWrongPassphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unlocked, got, err := unlockVault(vf, tt.passphrase)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("unlockVault() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("unlockVault() = %q, want %q", got, plaintext)
+			}
+			if !unlocked.matches(tt.passphrase) {
+				t.Error("unlocked vault does not match its passphrase")
+			}
+		})
+	}
+}
+
+func TestVaultSealUsesFreshNonce(t *testing.T) {
+	v, err := newVault("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := v.seal([]byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := v.seal([]byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Error("sealing twice gave the same envelope")
+	}
+}
+
+func TestVaultTampered(t *testing.T) {
+	v, err := newVault("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := v.seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vf := parseVaultFile(sealed)
+	vf.Ciphertext[0] ^= 1
+	if _, _, err := unlockVault(vf, "correct horse"); !errors.Is(err, errWrongPassphrase) {
+		t.Errorf("unlockVault() of tampered vault error = %v, want %v", err, errWrongPassphrase)
+	}
+}
+
+func TestParseVaultFilePlaintext(t *testing.T) {
+	for _, data := range []string{
+		`{"servers":[],"next_id":1}`,
+		`not json`,
+		`{"vault_version":1}`,
+	} {
+		if vf := parseVaultFile([]byte(data)); vf != nil {
+			t.Errorf("parseVaultFile(%s) = %+v, want nil", data, vf)
+		}
+	}
+}
+
+func TestHasSecrets(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   bool
+	}{
+		{"empty", Config{}, false},
+		{"host only", Config{Servers: []Server{{Host: "a"}}}, false},
+		{"password", Config{Servers: []Server{{Password: "p"}}}, true},
+		{"pem key", Config{Servers: []Server{{PemKey: "k"}}}, true},
+		{"server key passphrase", Config{Servers: []Server{{KeyPassphrase: "p"}}}, true},
+		{"group key", Config{Groups: []Group{{PemKey: "k"}}}, true},
+		{"group key passphrase", Config{Groups: []Group{{KeyPassphrase: "p"}}}, true},
+		{"group defaults only", Config{Groups: []Group{{Username: "u", Port: 2222}}}, false},
+		{"identity", Config{Identities: []Identity{{PrivateKey: "k"}}}, true},
+		{"identity passphrase", Config{Identities: []Identity{{Passphrase: "p"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.hasSecrets(); got != tt.want {
+				t.Errorf("hasSecrets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
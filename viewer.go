@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+// viewerMaxBytes caps how much of a file the viewer loads. Larger files are
+// tailed: only their last viewerMaxBytes are shown.
+const viewerMaxBytes = 2 << 20
+
+var (
+	viewerMatchStyle   = lipgloss.NewStyle().Background(lipgloss.Color("58"))
+	viewerCurrentStyle = lipgloss.NewStyle().Background(lipgloss.Color("214")).Foreground(lipgloss.Color("0"))
+	viewerGutterStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+)
+
+// fileViewer is a read-only view of a file in one of the SFTP panes
+type fileViewer struct {
+	Path        string
+	Size        int64  // size of the whole file
+	Offset      int64  // file offset of Data; non-zero when tailed
+	Data        []byte // nil while loading
+	Binary      bool
+	Hex         bool
+	LineNumbers bool
+	viewport    viewport.Model
+	// Search state. lineStarts maps each line of the file to its first
+	// line in the viewport, which differ once long lines are wrapped.
+	query      string
+	searching  bool
+	input      textinput.Model
+	matches    []int // indexes of the lines containing query
+	match      int
+	lineStarts []int
+}
+
+// viewerLoadedMsg delivers the contents of a file to the viewer
+type viewerLoadedMsg struct {
+	path   string
+	data   []byte
+	size   int64
+	offset int64
+	err    error
+}
+
+// readForViewer reads path from fsys, or only its tail when it is larger
+// than viewerMaxBytes. A tail starts at the first full line.
+func readForViewer(fsys fileSystem, path string) viewerLoadedMsg {
+	msg := viewerLoadedMsg{path: path}
+	f, err := fsys.Open(path)
+	if err != nil {
+		msg.err = err
+		return msg
+	}
+	defer f.Close()
+
+	if msg.size, err = f.Seek(0, io.SeekEnd); err != nil {
+		msg.err = err
+		return msg
+	}
+	if msg.size > viewerMaxBytes {
+		msg.offset = msg.size - viewerMaxBytes
+	}
+	if _, err := f.Seek(msg.offset, io.SeekStart); err != nil {
+		msg.err = err
+		return msg
+	}
+	if msg.data, err = io.ReadAll(io.LimitReader(f, viewerMaxBytes)); err != nil {
+		msg.err = err
+		return msg
+	}
+
+	if msg.offset > 0 {
+		if i := bytes.IndexByte(msg.data, '\n'); i >= 0 {
+			msg.data = msg.data[i+1:]
+			msg.offset += int64(i + 1)
+		}
+	}
+	return msg
+}
+
+// isBinary guesses whether data is not text: it has NUL bytes or is not
+// valid UTF-8. Only the start is checked, allowing for a rune cut off at
+// the end of the sample.
+func isBinary(data []byte) bool {
+	sample := data
+	truncated := len(sample) > 8192
+	if truncated {
+		sample = sample[:8192]
+	}
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+	for len(sample) > 0 {
+		r, size := utf8.DecodeRune(sample)
+		if r == utf8.RuneError && size == 1 {
+			return len(sample) >= utf8.UTFMax || !truncated
+		}
+		sample = sample[size:]
+	}
+	return false
+}
+
+// hexLines renders data like hexdump -C with perRow bytes a line, and
+// offsets counted from base.
+func hexLines(data []byte, base int64, perRow int) []string {
+	lines := make([]string, 0, len(data)/perRow+1)
+	for i := 0; i < len(data); i += perRow {
+		row := data[i:]
+		if len(row) > perRow {
+			row = row[:perRow]
+		}
+		var hexCols, ascii strings.Builder
+		for j := 0; j < perRow; j++ {
+			if j == perRow/2 {
+				hexCols.WriteByte(' ')
+			}
+			if j < len(row) {
+				fmt.Fprintf(&hexCols, "%02x ", row[j])
+			} else {
+				hexCols.WriteString("   ")
+			}
+		}
+		for _, c := range row {
+			if c < 32 || c > 126 {
+				c = '.'
+			}
+			ascii.WriteByte(c)
+		}
+		lines = append(lines, fmt.Sprintf("%08x  %s |%s|", base+int64(i), hexCols.String(), ascii.String()))
+	}
+	return lines
+}
+
+// isControl reports whether r is a C0 or C1 control character other than
+// tab and newline
+func isControl(r rune) bool {
+	return r != '\t' && r != '\n' && (r < 0x20 || r == 0x7f || (r >= 0x80 && r <= 0x9f))
+}
+
+// escapeControl replaces control characters with visible escapes like
+// \x1b, so remote text cannot move the cursor, retitle the window or send
+// other sequences to the terminal.
+func escapeControl(s string) string {
+	if strings.IndexFunc(s, isControl) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case !isControl(r):
+			b.WriteRune(r)
+		case r < 0x80:
+			fmt.Fprintf(&b, "\\x%02x", r)
+		default:
+			fmt.Fprintf(&b, "\\u%04x", r)
+		}
+	}
+	return b.String()
+}
+
+// textLines splits data into lines with tabs expanded, since the viewport
+// measures lines by their printed width. Control characters are escaped.
+func textLines(data []byte) []string {
+	text := escapeControl(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	text = strings.ReplaceAll(text, "\t", "    ")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// lines returns the file's lines in the current mode. Hex rows shrink to
+// 8 bytes when 16 would not fit.
+func (v *fileViewer) lines() []string {
+	if v.Hex {
+		perRow := 16
+		if v.viewport.Width < 80 {
+			perRow = 8
+		}
+		return hexLines(v.Data, v.Offset, perRow)
+	}
+	return textLines(v.Data)
+}
+
+// render lays the file out for the viewport: lines are wrapped to its width
+// behind an optional line number gutter, and search matches highlighted.
+func (v *fileViewer) render() {
+	lines := v.lines()
+	var re *regexp.Regexp
+	if v.query != "" {
+		re = regexp.MustCompile("(?i)" + regexp.QuoteMeta(v.query))
+	}
+
+	gutter := 0
+	if v.LineNumbers {
+		gutter = len(fmt.Sprint(len(lines))) + 3
+	}
+	width := v.viewport.Width - gutter
+	if width < 10 {
+		width = 10
+	}
+
+	var b strings.Builder
+	v.matches = v.matches[:0]
+	v.lineStarts = v.lineStarts[:0]
+	row := 0
+	for i, line := range lines {
+		v.lineStarts = append(v.lineStarts, row)
+		matched := re != nil && re.MatchString(line)
+		if matched {
+			v.matches = append(v.matches, i)
+		}
+		style := viewerMatchStyle
+		if matched && len(v.matches)-1 == v.match {
+			style = viewerCurrentStyle
+		}
+
+		for j, part := range strings.Split(runewidth.Wrap(line, width), "\n") {
+			if v.LineNumbers {
+				num := ""
+				if j == 0 {
+					num = fmt.Sprint(i + 1)
+				}
+				b.WriteString(viewerGutterStyle.Render(fmt.Sprintf("%*s │ ", gutter-3, num)))
+			}
+			if matched {
+				part = re.ReplaceAllStringFunc(part, func(s string) string { return style.Render(s) })
+			}
+			b.WriteString(part + "\n")
+			row++
+		}
+	}
+	v.viewport.SetContent(strings.TrimSuffix(b.String(), "\n"))
+}
+
+// showMatch scrolls to the current search match
+func (v *fileViewer) showMatch() {
+	if len(v.matches) == 0 {
+		return
+	}
+	v.render()
+	v.viewport.SetYOffset(v.lineStarts[v.matches[v.match]] - v.viewport.Height/3)
+}
+
+// --- Viewer view ---
+
+// openViewer shows the selected file of the focused pane
+func (m *model) openViewer() tea.Cmd {
+	fsys, dir := m.paneFS()
+	name := m.selectedName()
+	if fsys == nil || name == "" {
+		return nil
+	}
+	path := fsys.Join(dir, name)
+
+	input := textinput.New()
+	input.Prompt = "/"
+	input.CharLimit = 256
+	m.viewer = &fileViewer{Path: path, input: input, viewport: viewport.New(0, 0)}
+	m.resizeViewer()
+	m.state = viewerView
+	m.message = ""
+	return func() tea.Msg {
+		return readForViewer(fsys, path)
+	}
+}
+
+func (m *model) resizeViewer() {
+	if m.viewer == nil {
+		return
+	}
+	m.viewer.viewport.Width = m.width - 2
+	m.viewer.viewport.Height = m.height - 6
+	if m.viewer.viewport.Height < 3 {
+		m.viewer.viewport.Height = 3
+	}
+	if m.viewer.Data != nil {
+		m.viewer.render()
+	}
+}
+
+// viewerLoaded fills the viewer with a finished read. Binary files start
+// in hex mode and tailed files at their end.
+func (m *model) viewerLoaded(msg viewerLoadedMsg) {
+	v := m.viewer
+	if v == nil || v.Path != msg.path {
+		return
+	}
+	if msg.err != nil {
+		m.viewer = nil
+		m.state = sftpView
+		m.message = fmt.Sprintf("Error opening %s: %v", msg.path, msg.err)
+		return
+	}
+	v.Data, v.Size, v.Offset = msg.data, msg.size, msg.offset
+	if v.Data == nil {
+		v.Data = []byte{}
+	}
+	v.Binary = isBinary(v.Data)
+	v.Hex = v.Binary
+	v.render()
+	if v.Offset > 0 {
+		v.viewport.GotoBottom()
+	}
+}
+
+func (m model) updateViewerView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	v := m.viewer
+	if v.searching {
+		switch msg.String() {
+		case "esc":
+			v.searching = false
+			v.input.Blur()
+			return m, nil
+		case "enter":
+			v.searching = false
+			v.input.Blur()
+			v.query = v.input.Value()
+			v.match = 0
+			// Start from the first match below the top of the screen
+			v.render()
+			for i, line := range v.matches {
+				if v.lineStarts[line] >= v.viewport.YOffset {
+					v.match = i
+					break
+				}
+			}
+			v.showMatch()
+			if v.query != "" && len(v.matches) == 0 {
+				m.message = fmt.Sprintf("Pattern not found: %s", v.query)
+			}
+			return m, nil
+		}
+		var cmd tea.Cmd
+		v.input, cmd = v.input.Update(msg)
+		return m, cmd
+	}
+
+	m.message = ""
+	if v.Data == nil {
+		if s := msg.String(); s == "q" || s == "esc" || s == "ctrl+c" {
+			m.viewer = nil
+			m.state = sftpView
+		}
+		return m, nil
+	}
+
+	switch msg.String() {
+	case "ctrl+c", "q", "esc":
+		m.viewer = nil
+		m.state = sftpView
+		return m, nil
+
+	case "/":
+		v.searching = true
+		v.input.SetValue(v.query)
+		v.input.CursorEnd()
+		return m, v.input.Focus()
+
+	case "n", "N":
+		if len(v.matches) == 0 {
+			return m, nil
+		}
+		step := 1
+		if msg.String() == "N" {
+			step = len(v.matches) - 1
+		}
+		v.match = (v.match + step) % len(v.matches)
+		v.showMatch()
+		return m, nil
+
+	case "#":
+		v.LineNumbers = !v.LineNumbers
+		v.render()
+		return m, nil
+
+	case "x":
+		v.Hex = !v.Hex
+		v.match = 0
+		v.render()
+		v.viewport.GotoTop()
+		return m, nil
+
+	case "g", "home":
+		v.viewport.GotoTop()
+		return m, nil
+
+	case "G", "end":
+		v.viewport.GotoBottom()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	v.viewport, cmd = v.viewport.Update(msg)
+	return m, cmd
+}
+
+func (m model) viewViewer() string {
+	v := m.viewer
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("View: "+v.Path) + "\n")
+
+	if v.Data == nil {
+		b.WriteString(messageStyle.Render("Loading...") + "\n")
+		b.WriteString("\n" + helpStyle.Render("[q] close"))
+		return b.String()
+	}
+
+	info := FormatSize(v.Size)
+	if v.Offset > 0 {
+		info = fmt.Sprintf("last %s of %s", FormatSize(v.Size-v.Offset), FormatSize(v.Size))
+	}
+	if v.Binary {
+		info += " • binary"
+	}
+	if v.query != "" {
+		info += fmt.Sprintf(" • %q: %d lines", v.query, len(v.matches))
+	}
+	info += fmt.Sprintf(" • %3.f%%", v.viewport.ScrollPercent()*100)
+	b.WriteString(helpStyle.Render(info) + "\n")
+	b.WriteString(v.viewport.View() + "\n")
+
+	if v.searching {
+		b.WriteString(v.input.View())
+	} else {
+		mode := "[x] hex"
+		if v.Hex {
+			mode = "[x] text"
+		}
+		b.WriteString(helpStyle.Render("[/] search • [n/N] next/prev match • [#] line numbers • " + mode + " • [g/G] top/bottom • [q] close"))
+	}
+
+	if m.message != "" {
+		b.WriteString("\n" + errorStyle.Render(m.message))
+	}
+	return b.String()
+}